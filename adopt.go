@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// Links files downloaded by other tools into a creator's directory under the names
+// this tool would have given them, matching them by the hash in the site's file URLs.
+// Files that match no post are listed and left untouched.
+func adoptCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: kemono-dl adopt URL DIR")
+	}
+
+	profile, err := parseProfileURL(args[0])
+	if err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return adopt(ctx, profile, wd, args[1])
+}
+
+// Adopts the matching files found in the foreign directory into the creator's directory in the working directory
+func adopt(ctx context.Context, profile profileConfig, wd string, foreignDir string) error {
+	// Hashes the foreign files
+	foreign := map[string]string{}
+	err := filepath.WalkDir(foreignDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		foreign[sum] = path
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Hashed %d files in %s", len(foreign), foreignDir)
+
+	url := profile.URL()
+	name, err := getName(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	total, err := numberOfPosts(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch all posts: %w", err)
+	}
+
+	dir, prefix := creatorDirectory(wd, profile, name)
+	cfg := &config{}
+	indexed := indexedArchive(dir, profile.Service, cfg)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := saveProfile(dir, profile, name, indexed); err != nil {
+		log.Printf("Failed to save the profile: %s", err)
+	}
+
+	c := &creator{name: name, prefix: prefix, site: profile.Site, service: profile.Service, baseURL: profile.BaseURL, directory: dir, cfg: cfg, stats: &summary{}, indexed: indexed}
+	c.manifest, err = openManifest(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer c.manifest.Close()
+
+	// Matches the files of every post against the foreign files
+	adopted := map[string]bool{}
+	links, errc := streamPosts(ctx, url, total)
+	for post := range fetchPosts(ctx, links, profile.BaseURL, 3) {
+		if post.err != nil {
+			log.Printf("Failed to fetch post %s: %s", post.url, post.err)
+			continue
+		}
+
+		for _, f := range c.postFiles(post) {
+			file, dest := f.url, f.dest
+			sum, ok := urlContentHash(file)
+			source, found := foreign[sum]
+			if !ok || !found {
+				continue
+			}
+			adopted[sum] = true
+			if _, err := os.Stat(dest); err == nil {
+				continue
+			}
+
+			if err := linkFile(source, dest); err != nil {
+				log.Printf("Failed to adopt %s: %s", source, err)
+				continue
+			}
+			c.manifest.record(post, file, dest, statusAdopted, nil)
+			c.stats.files++
+		}
+	}
+	if err := <-errc; err != nil {
+		return fmt.Errorf("failed to fetch all posts: %w", err)
+	}
+
+	// Reports the files that match no post
+	var unmatched []string
+	for sum, path := range foreign {
+		if !adopted[sum] {
+			unmatched = append(unmatched, path)
+		}
+	}
+	sort.Strings(unmatched)
+	for _, path := range unmatched {
+		fmt.Println(path)
+	}
+
+	log.Printf("Adopted %d files into %s, %d files matched no post", c.stats.files, dir, len(unmatched))
+	return nil
+}
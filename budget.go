@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exit code of a run stopped by --max-duration, --max-bytes or --monthly-budget
+const exitTruncated = 3
+
+// Returned once the run used up its time or data budget
+var errBudgetExhausted = errors.New("run budget exhausted")
+
+// Returned once the month's data budget is used up, watch mode waits for the next month
+var errMonthlyBudget = fmt.Errorf("%w: --monthly-budget reached", errBudgetExhausted)
+
+// Limits of a single run, shared by all creators
+type budget struct {
+	mu       sync.Mutex
+	deadline time.Time
+	maxBytes int64
+	bytes    int64
+
+	// Data left of the monthly budget when the current cycle started, and the pages and
+	// files the run had transferred by then
+	monthlyBytes int64
+	monthlyStart int64
+}
+
+// Budget of the current run, unlimited unless configured
+var runBudget = &budget{}
+
+// Adds downloaded bytes to the budget
+func (b *budget) add(bytes int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += bytes
+}
+
+// Sets the data left of the monthly budget from now on
+func (b *budget) setMonthly(remaining int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.monthlyBytes = remaining
+	b.monthlyStart = transferredBytes()
+}
+
+// Returns an error describing the exhausted limit when no new file should be started
+func (b *budget) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+		return fmt.Errorf("%w: --max-duration reached", errBudgetExhausted)
+	}
+	if b.maxBytes > 0 && b.bytes >= b.maxBytes {
+		return fmt.Errorf("%w: --max-bytes reached after %s", errBudgetExhausted, formatSize(b.bytes))
+	}
+	// Pages count against the monthly budget like files, as they do in the usage file
+	if used := transferredBytes() - b.monthlyStart; b.monthlyBytes > 0 && used >= b.monthlyBytes {
+		return fmt.Errorf("%w after %s", errMonthlyBudget, formatSize(used))
+	}
+
+	return nil
+}
+
+// Number of bytes given with an optional K, M, G or T suffix, e.g. 500G
+type byteSize int64
+
+var _ flag.Value = (*byteSize)(nil)
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if i := strings.IndexByte("KMGT", value[n-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+
+	*s = byteSize(n * float64(multiplier))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Replaces the run budget for the test
+func testBudget(t *testing.T) {
+	t.Helper()
+	saved := runBudget
+	runBudget = &budget{}
+	t.Cleanup(func() {
+		runBudget = saved
+	})
+}
+
+// Writes the usage file of the base directory with the data transferred in the month
+func writeUsage(t *testing.T, base string, month string, monthly usageCounters) {
+	t.Helper()
+	data, err := json.Marshal(usage{Month: month, Monthly: monthly, Total: monthly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, usageFileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonthlyBudgetCountsPages(t *testing.T) {
+	testBudget(t)
+	base := t.TempDir()
+	writeUsage(t, base, currentMonth(), usageCounters{Pages: 400, Files: 400})
+
+	left, err := startMonthlyBudget(base, 1000)
+	if err != nil || !left {
+		t.Fatalf("startMonthlyBudget = %t, %v, want budget left", left, err)
+	}
+	if err := runBudget.check(); err != nil {
+		t.Fatalf("check with 200 bytes left = %v", err)
+	}
+
+	// Pages use up the budget like files
+	transferred.pages.Add(150)
+	if err := runBudget.check(); err != nil {
+		t.Fatalf("check with 50 bytes left = %v", err)
+	}
+	transferred.pages.Add(50)
+	if err := runBudget.check(); !errors.Is(err, errMonthlyBudget) || !errors.Is(err, errBudgetExhausted) {
+		t.Errorf("check with the budget used up = %v, want the monthly budget reached", err)
+	}
+}
+
+func TestMonthlyBudgetNewMonth(t *testing.T) {
+	testBudget(t)
+	base := t.TempDir()
+
+	// A used up month refuses to start
+	writeUsage(t, base, currentMonth(), usageCounters{Files: 1000})
+	if left, err := startMonthlyBudget(base, 1000); err != nil || left {
+		t.Fatalf("startMonthlyBudget of a used up month = %t, %v", left, err)
+	}
+
+	// The next month starts over, counting only what was transferred since
+	writeUsage(t, base, "2000-01", usageCounters{Files: 1000})
+	transferred.files.Add(5000)
+	if left, err := startMonthlyBudget(base, 1000); err != nil || !left {
+		t.Fatalf("startMonthlyBudget of a new month = %t, %v", left, err)
+	}
+	if err := runBudget.check(); err != nil {
+		t.Errorf("check at the start of a new month = %v", err)
+	}
+}
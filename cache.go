@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the page cache directory in the base directory
+const cacheDirName = ".kemono-dl-cache"
+
+// A cached page response together with its validators
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	Stored       time.Time `json:"stored"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Body         []byte    `json:"body"`
+}
+
+// On-disk cache of page responses keyed by URL. File downloads are never cached.
+type responseCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// Cache of the current run, nil when caching is disabled
+var pageCache *responseCache
+
+// Returns the path of the cache file for the URL
+func (c *responseCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Returns the cached entry for the URL, if there is one
+func (c *responseCache) get(url string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// Stores the entry in the cache
+func (c *responseCache) put(entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(c.path(entry.URL), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Failed to cache %s: %s", entry.URL, err)
+	}
+}
+
+// Returns the body of a page, served from the cache while it is fresh.
+// Fresh hits skip the rate limiter entirely, stale entries with validators
+// are revalidated with a conditional request.
+func (c *responseCache) fetch(ctx context.Context, url string, header http.Header) (io.ReadCloser, error) {
+	entry, ok := c.get(url)
+	if ok && time.Since(entry.Stored) < c.ttl {
+		return io.NopCloser(bytes.NewReader(entry.Body)), nil
+	}
+
+	header = header.Clone()
+	if ok && entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+	if ok && entry.LastModified != "" {
+		header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	res, err := doRequest(ctx, http.MethodGet, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// The cached body is still valid
+	if res.StatusCode == http.StatusNotModified && ok {
+		entry.Stored = time.Now()
+		c.put(entry)
+		return io.NopCloser(bytes.NewReader(entry.Body)), nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(&cacheEntry{
+		URL:          url,
+		Stored:       time.Now(),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Body:         body,
+	})
+
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// Runs the cache maintenance command
+func cacheCommand(args []string) error {
+	if len(args) != 1 || args[0] != "clear" {
+		return errors.New("usage: kemono-dl cache clear")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(wd, cacheDirName)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %s\n", dir)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Faults injected into the responses, used to reproduce failures of the site locally
+const (
+	faultRateLimit = iota
+	faultServerError
+	faultReset
+	faultSlow
+	faultTruncate
+	faultCount
+)
+
+// Delay of the responses slowed down by the fault injector
+var faultSlowDelay = 3 * time.Second
+
+// Randomly replaces responses with failures, set by --chaos.
+// The faults are drawn from a seeded source so a run can be reproduced.
+type faultInjector struct {
+	mu          sync.Mutex
+	rand        *rand.Rand
+	probability float64
+}
+
+// Fault injector of the run, nil unless --chaos is set
+var faults *faultInjector
+
+// Parses the --chaos value, e.g. "p=0.05" or "p=0.05,seed=42"
+func parseChaos(value string) (*faultInjector, error) {
+	probability, seed := 0.0, time.Now().UnixNano()
+	for _, option := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(option), "=")
+		var err error
+		switch key {
+		case "p":
+			probability, err = strconv.ParseFloat(val, 64)
+			if err == nil && (probability < 0 || probability > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+		case "seed":
+			seed, err = strconv.ParseInt(val, 10, 64)
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid chaos option %q: %w", option, err)
+		}
+	}
+
+	return &faultInjector{rand: rand.New(rand.NewSource(seed)), probability: probability}, nil
+}
+
+// Returns the fault to inject into the next response, or -1 for none
+func (f *faultInjector) next() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rand.Float64() >= f.probability {
+		return -1
+	}
+	return f.rand.Intn(faultCount)
+}
+
+// Injects the faults of --chaos into the responses
+func injectFaults(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if faults == nil {
+			return next(req)
+		}
+
+		switch faults.next() {
+		case faultRateLimit:
+			return faultResponse(req, http.StatusTooManyRequests), nil
+		case faultServerError:
+			return faultResponse(req, http.StatusServiceUnavailable), nil
+		case faultReset:
+			return nil, fmt.Errorf("chaos: %w", syscall.ECONNRESET)
+		case faultSlow:
+			if err := sleep(req.Context(), faultSlowDelay); err != nil {
+				return nil, err
+			}
+		case faultTruncate:
+			res, err := next(req)
+			if err == nil {
+				res.Body = &truncatedBody{ReadCloser: res.Body, n: res.ContentLength / 2}
+			}
+			return res, err
+		}
+
+		return next(req)
+	}
+}
+
+// Returns an empty response with the status
+func faultResponse(req *http.Request, status int) *http.Response {
+	header := http.Header{}
+	if status == http.StatusTooManyRequests {
+		header.Set("Retry-After", "1")
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
+
+// Response body cut off after n bytes as if the connection dropped
+type truncatedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
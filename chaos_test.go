@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	tests := []struct {
+		value       string
+		probability float64
+		valid       bool
+	}{
+		{"p=0.05", 0.05, true},
+		{"p=0.5,seed=42", 0.5, true},
+		{" p=1 , seed=-3", 1, true},
+		{"p=0", 0, true},
+		{"p=1.5", 0, false},
+		{"p=-0.1", 0, false},
+		{"p=often", 0, false},
+		{"p=0.1,seed=x", 0, false},
+		{"rate=0.1", 0, false},
+	}
+
+	for _, test := range tests {
+		f, err := parseChaos(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("parseChaos(%q) = %v, want valid %t", test.value, err, test.valid)
+			continue
+		}
+		if err == nil && f.probability != test.probability {
+			t.Errorf("parseChaos(%q) probability = %v, want %v", test.value, f.probability, test.probability)
+		}
+	}
+}
+
+func TestChaosSeed(t *testing.T) {
+	draw := func() []int {
+		f, err := parseChaos("p=0.5,seed=7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var drawn []int
+		for i := 0; i < 200; i++ {
+			drawn = append(drawn, f.next())
+		}
+		return drawn
+	}
+
+	// The same seed injects the same faults
+	first, second := draw(), draw()
+	seen := map[int]bool{}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("fault %d differs between runs with the same seed: %d and %d", i, first[i], second[i])
+		}
+		seen[first[i]] = true
+	}
+	for fault := -1; fault < faultCount; fault++ {
+		if !seen[fault] {
+			t.Errorf("200 draws never gave fault %d", fault)
+		}
+	}
+}
+
+func TestDownloadCreatorChaos(t *testing.T) {
+	testRun(t)
+	retryConfig.maxRetries = 10
+	delay := faultSlowDelay
+	faultSlowDelay = time.Millisecond
+	var err error
+	if faults, err = parseChaos("p=0.2,seed=1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		faults, faultSlowDelay = nil, delay
+	})
+
+	site := newMockSite(t, 3)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst, noManifest: true}
+
+	// Files cut off by the faults are resumed by the next runs until every file arrives
+	runs := 0
+	for stats := (summary{failures: 1}); stats.failures > 0; runs++ {
+		if runs == 3 {
+			t.Fatalf("%d files still failed after %d runs", stats.failures, runs)
+		}
+		if stats, err = downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(wd, "kemono", "patreon", "Bob [1]")
+	for i := 0; i < 3; i++ {
+		id := mockPostID(i)
+		for name, link := range map[string]string{
+			"Bob_" + id + "_00_image.jpg":   mockFile(id+"-main", "image.jpg"),
+			"Bob_" + id + "_01_archive.zip": mockFile(id+"-zip", "archive.zip"),
+			"Bob_" + id + "_02_image.jpg":   mockFile(id+"-second", "image.jpg"),
+		} {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			path, _, _ := strings.Cut(link, "?")
+			if err != nil || !bytes.Equal(content, mockContents[path]) {
+				t.Errorf("%s differs from the file on the site: %v", name, err)
+			}
+		}
+	}
+}
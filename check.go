@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Records a file missing locally in the summary, its size is taken from a HEAD request
+func checkFile(ctx context.Context, url string, stats *summary) {
+	stats.missingFiles++
+	res, err := doRequest(ctx, http.MethodHead, url, nil)
+	if err == nil {
+		res.Body.Close()
+		if res.ContentLength > 0 {
+			stats.missingBytes += res.ContentLength
+		}
+	}
+}
+
+// Formats a number of bytes for humans
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Files smaller than this are downloaded over a single connection with --chunks
+const chunkedMinSize = 64 << 20
+
+// Number of attempts at a single range, the request itself is retried by the client as well
+const chunkAttempts = 3
+
+// Returns the size of the file when the server serves byte ranges of it, -1 otherwise
+func rangeSize(ctx context.Context, url string) int64 {
+	res, err := doRequest(ctx, http.MethodGet, url, http.Header{"Range": {"bytes=0-0"}, "Accept-Encoding": {"identity"}})
+	if err != nil {
+		return -1
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return -1
+	}
+
+	// Content-Range: bytes 0-0/{size}
+	_, total, _ := strings.Cut(res.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// Suffix of the file next to an unfinished chunked download recording the bytes received of each range
+const chunkStateSuffix = ".chunks"
+
+// Progress of an unfinished chunked download
+type chunkState struct {
+	Size int64 `json:"size"`
+	// Bytes received from the start of each range
+	Received []int64 `json:"received"`
+}
+
+// Reads the progress of the unfinished chunked download of a file of the size in the given number
+// of ranges, nil when there is none or it belongs to a download of another size or number of ranges
+func readChunkState(partial string, size int64, ranges int) *chunkState {
+	data, err := os.ReadFile(partial + chunkStateSuffix)
+	if err != nil {
+		return nil
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil || state.Size != size || len(state.Received) != ranges {
+		return nil
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() != size {
+		return nil
+	}
+	return &state
+}
+
+// Records the progress of the chunked download once the received bytes are on the disk
+func (s *chunkState) save(out *os.File) error {
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(out.Name()+chunkStateSuffix, data, 0644)
+}
+
+// Downloads the file in the given number of ranges at once into a preallocated file among the
+// unfinished downloads, which is checked against its size and content hash before it is moved
+// into place. The ranges received by an earlier attempt are resumed.
+func chunkedDownload(ctx context.Context, url string, file string, size int64, chunks int) error {
+	partial := partialPath(url, file)
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return err
+	}
+
+	chunkSize := (size + int64(chunks) - 1) / int64(chunks)
+	ranges := int((size + chunkSize - 1) / chunkSize)
+	state := readChunkState(partial, size, ranges)
+
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &chunkState{Size: size, Received: make([]int64, ranges)}
+		// Starts over with an empty file of the full size
+		err = out.Truncate(0)
+		if err == nil {
+			err = out.Truncate(size)
+		}
+		if err == nil {
+			err = state.save(out)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(partial)
+			os.Remove(partial + chunkStateSuffix)
+			return err
+		}
+	} else {
+		log.Printf("Resuming the chunked download of %s", filepath.Base(file))
+	}
+
+	// The first failed range stops the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, ranges)
+	var progress sync.Mutex
+	for i := 0; i < ranges; i++ {
+		start := int64(i)*chunkSize + state.Received[i]
+		end := int64(i+1)*chunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		if start > end {
+			errs <- nil
+			continue
+		}
+		go func(i int, start, end int64) {
+			n, err := downloadRange(ctx, url, out, start, end)
+
+			progress.Lock()
+			state.Received[i] += n
+			if saveErr := state.save(out); err == nil {
+				err = saveErr
+			}
+			progress.Unlock()
+			errs <- err
+		}(i, start, end)
+	}
+
+	var failure error
+	for i := 0; i < ranges; i++ {
+		if err := <-errs; err != nil && failure == nil {
+			failure = err
+			cancel()
+		}
+	}
+	if err := out.Close(); err != nil && failure == nil {
+		failure = err
+	}
+
+	// Keeps the received ranges for the next attempt, a complete file not matching its hash starts over
+	if failure != nil {
+		return failure
+	}
+	if err := verifyDownload(url, partial, size); err != nil {
+		os.Remove(partial)
+		os.Remove(partial + chunkStateSuffix)
+		return err
+	}
+
+	os.Remove(partial + chunkStateSuffix)
+	return moveFile(partial, file)
+}
+
+// Downloads the bytes from start to end inclusive into the same place of the file,
+// continuing after the received bytes when the transfer breaks off. Returns the number of bytes received.
+func downloadRange(ctx context.Context, url string, out *os.File, start int64, end int64) (int64, error) {
+	received := int64(0)
+	for attempt := 1; ; attempt++ {
+		n, err := copyRange(ctx, url, out, start, end)
+		start += n
+		received += n
+		if err == nil {
+			return received, nil
+		}
+		if attempt == chunkAttempts || ctx.Err() != nil {
+			return received, err
+		}
+		log.Printf("Retrying bytes %d-%d of %s: %s", start, end, url, err)
+	}
+}
+
+// Copies a single range of the file, returns the number of bytes written
+func copyRange(ctx context.Context, url string, out *os.File, start int64, end int64) (int64, error) {
+	header := http.Header{
+		"Range":           {fmt.Sprintf("bytes=%d-%d", start, end)},
+		"Accept-Encoding": {"identity"},
+	}
+	res, err := doRequest(ctx, http.MethodGet, url, header)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("%s: the server stopped serving byte ranges (HTTP %d)", url, res.StatusCode)
+	}
+
+	want := end - start + 1
+	n, err := copyDownload(io.NewOffsetWriter(out, start), io.LimitReader(res.Body, want))
+	if err == nil && n < want {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Checks the size of the downloaded file and its content against the hash in its URL
+func verifyDownload(url string, file string, size int64) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return fmt.Errorf("%s: downloaded %d of %d bytes", url, info.Size(), size)
+	}
+
+	want, ok := urlContentHash(url)
+	if !ok {
+		return nil
+	}
+	sum, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	if sum != want {
+		return fmt.Errorf("%s: the downloaded file does not match its hash", url)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChunkedDownload(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("chunked", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "Bob_1_video.mp4")
+	if err := chunkedDownload(context.Background(), site.URL+link, dest, int64(len(content)), 4); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("downloaded content differs")
+	}
+
+	// Nothing of the download is left behind
+	if entries, _ := os.ReadDir(filepath.Join(dir, partialDirName)); len(entries) != 0 {
+		t.Errorf("left %v in %s", entries, partialDirName)
+	}
+	if _, err := os.Stat(dest + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("left %s.partial", filepath.Base(dest))
+	}
+}
+
+func TestChunkedDownloadResumes(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("resumed chunks", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+	size := int64(len(content))
+	chunkSize := (size + 3) / 4
+
+	// An earlier attempt received the first two ranges and half of the third
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "Bob_1_video.mp4")
+	partial := partialPath(site.URL+link, dest)
+	received := append(bytes.Clone(content[:2*chunkSize+chunkSize/2]), make([]byte, size-2*chunkSize-chunkSize/2)...)
+	state, _ := json.Marshal(chunkState{Size: size, Received: []int64{chunkSize, chunkSize, chunkSize / 2, 0}})
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, received, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial+chunkStateSuffix, state, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chunkedDownload(context.Background(), site.URL+link, dest, size, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("resumed content differs")
+	}
+
+	// Only the rest of the third range and the fourth range were requested
+	if n := site.count(path); n != 2 {
+		t.Errorf("requested %d ranges, want 2", n)
+	}
+	if _, err := os.Stat(partial + chunkStateSuffix); !os.IsNotExist(err) {
+		t.Error("the progress of the finished download was kept")
+	}
+}
+
+func TestChunkedDownloadKeepsProgress(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("failed chunks", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+
+	// Every attempt at the range fails
+	for i := 0; i < chunkAttempts; i++ {
+		site.fail(path, mockResponse{status: http.StatusNotFound})
+	}
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "Bob_1_video.mp4")
+	if err := chunkedDownload(context.Background(), site.URL+link, dest, int64(len(content)), 1); err == nil {
+		t.Fatal("chunkedDownload succeeded with a failing range")
+	}
+	partial := partialPath(site.URL+link, dest)
+	for _, file := range []string{partial, partial + chunkStateSuffix} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("the unfinished download was not kept: %s", err)
+		}
+	}
+
+	// The next attempt finishes the download
+	if err := chunkedDownload(context.Background(), site.URL+link, dest, int64(len(content)), 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("resumed content differs")
+	}
+}
+
+func TestCleanPartialsRemovesChunkProgress(t *testing.T) {
+	dir := t.TempDir()
+	partial := filepath.Join(dir, partialDirName, "abc.mp4")
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{partial, partial + chunkStateSuffix} {
+		if err := os.WriteFile(file, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The progress is removed with its download, even when it was written more recently
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(partial, old, old); err != nil {
+		t.Fatal(err)
+	}
+	cleanPartials(dir, 24*time.Hour)
+	for _, file := range []string{partial, partial + chunkStateSuffix} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was kept", filepath.Base(file))
+		}
+	}
+}
+
+func TestDownloadFileDiscardsChunked(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("discarded chunks", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+
+	// A preallocated chunked download is not taken for a complete file
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "Bob_1_video.mp4")
+	partial := partialPath(site.URL+link, dest)
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, make([]byte, len(content)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial+chunkStateSuffix, []byte(`{"size": 1, "received": [0]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := downloadFile(withFileTransfer(context.Background(), site.profile().URL()), site.URL+link, dest, &config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("downloaded content differs")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Difference to the site's clock above which the local clock is reported as wrong
+const maxClockSkew = 5 * time.Minute
+
+// Difference between the site's clock and the local clock, measured from the first response with a Date header.
+// Positive when the site's clock is ahead.
+var clockSkew struct {
+	once   sync.Once
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+// Returns the measured difference between the site's clock and the local clock
+func siteClockSkew() time.Duration {
+	clockSkew.mu.Lock()
+	defer clockSkew.mu.Unlock()
+	return clockSkew.offset
+}
+
+// Compares the local clock with the Date header of the first response, warning when they disagree,
+// since a wrong clock breaks the Retry-After dates
+func checkClock(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := next(req)
+		if err != nil {
+			return res, err
+		}
+
+		date, dateErr := http.ParseTime(res.Header.Get("Date"))
+		if dateErr != nil {
+			return res, err
+		}
+		clockSkew.once.Do(func() {
+			offset := date.Sub(time.Now()).Round(time.Second)
+			clockSkew.mu.Lock()
+			clockSkew.offset = offset
+			clockSkew.mu.Unlock()
+
+			if offset > maxClockSkew || offset < -maxClockSkew {
+				log.Printf("WARNING: the local clock is %s off the site's clock, Retry-After dates are corrected for it", offset.Abs())
+			}
+		})
+		return res, err
+	}
+}
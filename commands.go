@@ -0,0 +1,21 @@
+package main
+
+// Maintenance commands, run as `kemono-dl COMMAND [ARGS]` instead of a URL
+var commands = map[string]func(args []string) error{
+	"adopt":            adoptCommand,
+	"cache":            cacheCommand,
+	"decrypt":          decryptCommand,
+	"export":           exportCommand,
+	"export-creators":  exportCreatorsCommand,
+	"fix-extensions":   fixExtensionsCommand,
+	"import":           importCommand,
+	"index":            indexCommand,
+	"lookup":           lookupCommand,
+	"migrate-metadata": migrateMetadataCommand,
+	"plan-repair":      planRepairCommand,
+	"playlist":         playlistCommand,
+	"repair":           repairCommand,
+	"serve":            serveCommand,
+	"stats":            statsCommand,
+	"sums":             sumsCommand,
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Name of the file in the creator's directory recording where the creator was downloaded from
+const profileName = "profile.json"
+
+// Version of the profile.json format written by this version, profiles
+// without a version are from before the format was versioned
+const profileSchema = 2
+
+// Creator details saved next to the downloaded files
+type savedProfile struct {
+	Schema  int    `json:"_schema"`
+	URL     string `json:"url"`
+	Site    string `json:"site"`
+	Service string `json:"service"`
+	User    string `json:"user"`
+	Name    string `json:"name"`
+	// Files of the archive are named with their position in the post
+	Indexed bool `json:"indexed_files,omitempty"`
+	// Name patterns of the files left out or only downloaded, added to the options
+	IgnorePatterns  []string `json:"ignore_patterns,omitempty"`
+	IncludePatterns []string `json:"include_patterns,omitempty"`
+}
+
+// Reads the creator's details saved in the creator's directory, reports whether there were any
+func readProfile(directory string) (savedProfile, bool) {
+	var saved savedProfile
+	data, err := os.ReadFile(filepath.Join(directory, profileName))
+	if err != nil || json.Unmarshal(data, &saved) != nil {
+		return saved, false
+	}
+	return saved, true
+}
+
+// Writes the creator's details into the creator's directory, an archive once
+// using indexed file names keeps using them and the name patterns set by hand are kept.
+// A profile written by a newer version is left alone.
+func saveProfile(directory string, profile profileConfig, name string, indexed bool) error {
+	file := filepath.Join(directory, profileName)
+	if err := checkSchema(file, profileSchema); err != nil {
+		return err
+	}
+	saved, _ := readProfile(directory)
+
+	data, err := json.MarshalIndent(savedProfile{
+		Schema:          profileSchema,
+		URL:             profile.URL(),
+		Site:            profile.Site,
+		Service:         profile.Service,
+		User:            profile.User,
+		Name:            name,
+		Indexed:         indexed || saved.Indexed,
+		IgnorePatterns:  saved.IgnorePatterns,
+		IncludePatterns: saved.IncludePatterns,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeMetadata(file, data)
+	return err
+}
+
+// An archived creator listed by the export-creators command
+type archivedCreator struct {
+	savedProfile
+	Directory    string    `json:"directory"`
+	LastArchived time.Time `json:"last_archived"`
+}
+
+// Returns the creators with a saved profile in the creator directories of the base directory
+func archivedCreators(base string) ([]archivedCreator, error) {
+	dirs, err := creatorDirectories(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var creators []archivedCreator
+	for _, dir := range dirs {
+		file := filepath.Join(base, dir, profileName)
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// A damaged profile is left for the next download of the creator to rewrite,
+		// listing the creators never changes the archive
+		var c archivedCreator
+		if err := json.Unmarshal(data, &c.savedProfile); err != nil {
+			log.Printf("Skipping %s: %s", file, err)
+			continue
+		}
+		c.Directory = filepath.Dir(file)
+
+		// The newest downloaded post dates the last time the creator was archived
+		posts, err := localPosts(c.Directory, directoryPrefix(c.Directory))
+		if err != nil {
+			return nil, err
+		}
+		for _, files := range posts {
+			for _, name := range files {
+				info, err := os.Stat(filepath.Join(c.Directory, name))
+				if err == nil && info.ModTime().After(c.LastArchived) {
+					c.LastArchived = info.ModTime()
+				}
+			}
+		}
+
+		creators = append(creators, c)
+	}
+
+	sort.Slice(creators, func(i, j int) bool {
+		return creators[i].URL < creators[j].URL
+	})
+	return creators, nil
+}
+
+// OPML document listing the archived creators
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Items   []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Text    string `xml:"text,attr"`
+	Type    string `xml:"type,attr"`
+	HTMLURL string `xml:"htmlUrl,attr"`
+	Service string `xml:"service,attr"`
+	User    string `xml:"user,attr"`
+	Updated string `xml:"lastArchived,attr,omitempty"`
+}
+
+// Prints the creators archived in the base directory as a list of URLs, JSON or OPML
+func exportCreatorsCommand(args []string) error {
+	flags := flag.NewFlagSet("export-creators", flag.ContinueOnError)
+	format := flags.String("format", "text", "Output format, text, json or opml")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return errors.New("usage: kemono-dl export-creators [--format text|json|opml] [DIR]")
+	}
+
+	base := flags.Arg(0)
+	if base == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		base = wd
+	}
+
+	creators, err := archivedCreators(base)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		for _, c := range creators {
+			fmt.Println(c.URL)
+		}
+		return nil
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(creators)
+	case "opml":
+		doc := opmlDocument{Version: "2.0", Title: "kemono-dl creators"}
+		for _, c := range creators {
+			outline := opmlOutline{Text: c.Name, Type: "link", HTMLURL: c.URL, Service: c.Service, User: c.User}
+			if !c.LastArchived.IsZero() {
+				outline.Updated = c.LastArchived.UTC().Format(time.RFC3339)
+			}
+			doc.Items = append(doc.Items, outline)
+		}
+
+		data, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(xml.Header + string(data))
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+}
+
+// Metadata files of a creator directory upgraded by migrate-metadata, with the function
+// returning the upgraded content, or nil when the file already has the current format
+var metadataMigrations = []struct {
+	name    string
+	migrate func(data []byte) ([]byte, error)
+}{
+	{profileName, migrateProfile},
+	{removedPostsName, migrateRemovedPosts},
+	{manifestName, migrateManifest},
+}
+
+// Error of a metadata file written by a newer version, left alone by the migration
+var errNewerSchema = errors.New("written by a newer version of kemono-dl")
+
+// Upgrades a profile.json, the first version had the same fields without the version and the indexed file names
+func migrateProfile(data []byte) ([]byte, error) {
+	var saved savedProfile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	if saved.Schema > profileSchema {
+		return nil, errNewerSchema
+	}
+	if saved.Schema == profileSchema {
+		return nil, nil
+	}
+
+	saved.Schema = profileSchema
+	return json.MarshalIndent(saved, "", "  ")
+}
+
+// Upgrades a removed_posts.json, the first version was the list of the posts alone
+func migrateRemovedPosts(data []byte) ([]byte, error) {
+	schema := metadataSchema(data)
+	if schema > removedPostsSchema {
+		return nil, errNewerSchema
+	}
+	if schema == removedPostsSchema {
+		return nil, nil
+	}
+
+	var posts []removedPost
+	if err := json.Unmarshal(data, &posts); err != nil {
+		return nil, err
+	}
+	if posts == nil {
+		posts = []removedPost{}
+	}
+	return json.MarshalIndent(removedPostsReport{Schema: removedPostsSchema, Posts: posts}, "", "  ")
+}
+
+// Upgrades a manifest.jsonl, the first version had the same entries without the version line
+func migrateManifest(data []byte) ([]byte, error) {
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	schema := metadataSchema(first)
+	if schema > manifestSchema {
+		return nil, errNewerSchema
+	}
+	if schema == manifestSchema {
+		return nil, nil
+	}
+
+	return append(manifestHeader(), data...), nil
+}
+
+// Upgrades the metadata files of the creators archived in the base directory to the current format
+func migrateMetadataCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate-metadata", flag.ContinueOnError)
+	backup := flags.Bool("backup", false, "Keep the previous version of every upgraded file with the .bak suffix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return errors.New("usage: kemono-dl migrate-metadata [--backup] [DIR]")
+	}
+
+	base := flags.Arg(0)
+	if base == "" {
+		base = "."
+	}
+
+	dirs, err := creatorDirectories(base)
+	if err != nil {
+		return err
+	}
+
+	upgraded, files := 0, 0
+	for _, dir := range dirs {
+		for _, migration := range metadataMigrations {
+			file := filepath.Join(base, dir, migration.name)
+			data, err := os.ReadFile(file)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			files++
+
+			migrated, err := migration.migrate(data)
+			if err != nil {
+				log.Printf("Skipping %s: %s", file, err)
+				continue
+			}
+			if migrated == nil {
+				continue
+			}
+
+			if *backup {
+				if err := writeFileAtomic(file+".bak", data, 0644); err != nil {
+					return err
+				}
+			}
+			if err := writeFileAtomic(file, migrated, 0644); err != nil {
+				return err
+			}
+			upgraded++
+		}
+	}
+
+	log.Printf("Upgraded %d of %d metadata files to the current version", upgraded, files)
+	return nil
+}
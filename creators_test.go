@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchivedCreatorsSkipsDamagedProfiles(t *testing.T) {
+	base := t.TempDir()
+	good := filepath.Join(base, "kemono", "patreon", "Bob [1]")
+	damaged := filepath.Join(base, "kemono", "patreon", "Eve [2]")
+	for _, dir := range []string{good, damaged} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveProfile(good, profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "patreon", User: "1"}, "Bob", true); err != nil {
+		t.Fatal(err)
+	}
+	broken := []byte(`{"url": "https://kemono.su/patreon/user/2", "na`)
+	if err := os.WriteFile(filepath.Join(damaged, profileName), broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	creators, err := archivedCreators(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creators) != 1 || creators[0].Name != "Bob" {
+		t.Fatalf("archivedCreators = %+v, want only Bob", creators)
+	}
+
+	// Listing the creators leaves the damaged profile as it was
+	entries, _ := os.ReadDir(damaged)
+	if len(entries) != 1 || entries[0].Name() != profileName {
+		t.Errorf("damaged directory holds %v, want only %s", entries, profileName)
+	}
+	if data, _ := os.ReadFile(filepath.Join(damaged, profileName)); string(data) != string(broken) {
+		t.Error("the damaged profile was changed")
+	}
+}
+
+func TestSaveProfileNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	newer := []byte(`{"_schema": 99, "url": "https://kemono.su/patreon/user/1", "name": "Bob", "tags": ["art"]}`)
+	if err := os.WriteFile(filepath.Join(dir, profileName), newer, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile of a newer version keeps its version and the fields unknown here
+	err := saveProfile(dir, profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "patreon", User: "1"}, "Bob", false)
+	if err == nil {
+		t.Error("saveProfile overwrote a profile of a newer version")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, profileName)); string(data) != string(newer) {
+		t.Errorf("profile changed to %s", data)
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	base := t.TempDir()
+	legacy := filepath.Join(base, "kemono", "patreon", "Bob [1]")
+	newer := filepath.Join(base, "kemono", "patreon", "Eve [2]")
+	files := map[string]string{
+		filepath.Join(legacy, profileName):      `{"url": "https://kemono.su/patreon/user/1", "site": "kemono", "service": "patreon", "user": "1", "name": "Bob"}`,
+		filepath.Join(legacy, removedPostsName): `[{"post_id": "5", "reason": "post page not found", "files": []}]`,
+		filepath.Join(legacy, manifestName):     `{"post_id":"1","path":"Bob_1_a.jpg","status":"downloaded"}` + "\n",
+		filepath.Join(newer, profileName):       `{"_schema": 99, "url": "https://kemono.su/patreon/user/2", "name": "Eve"}`,
+		filepath.Join(newer, removedPostsName):  `{"_schema": 99, "posts": []}`,
+		filepath.Join(newer, manifestName):      `{"_schema":99}` + "\n",
+	}
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateMetadataCommand([]string{"--backup", base}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every legacy file is stamped with the current version and kept aside
+	wantSchemas := map[string]int{profileName: profileSchema, removedPostsName: removedPostsSchema}
+	for name, schema := range wantSchemas {
+		data, _ := os.ReadFile(filepath.Join(legacy, name))
+		if got := metadataSchema(data); got != schema {
+			t.Errorf("%s has version %d, want %d", name, got, schema)
+		}
+	}
+	if version, err := manifestVersion(filepath.Join(legacy, manifestName)); err != nil || version != manifestSchema {
+		t.Errorf("manifest has version %d, %v, want %d", version, err, manifestSchema)
+	}
+	for file, content := range files {
+		if filepath.Dir(file) != legacy {
+			continue
+		}
+		if backup, _ := os.ReadFile(file + ".bak"); string(backup) != content {
+			t.Errorf("backup of %s = %q, want %q", filepath.Base(file), backup, content)
+		}
+	}
+
+	// The readers still find the entries of the upgraded files
+	saved, ok := readProfile(legacy)
+	if !ok || saved.Name != "Bob" {
+		t.Errorf("readProfile = %+v, %t", saved, ok)
+	}
+	plan, err := planRepair(legacy)
+	if err != nil || len(plan.Redownload) != 1 || plan.Redownload[0].Path != "Bob_1_a.jpg" {
+		t.Errorf("planRepair of the upgraded manifest = %+v, %v", plan, err)
+	}
+
+	// The files of a newer version are left alone
+	for file, content := range files {
+		if filepath.Dir(file) != newer {
+			continue
+		}
+		if data, _ := os.ReadFile(file); string(data) != content {
+			t.Errorf("%s of a newer version changed to %q", filepath.Base(file), data)
+		}
+	}
+}
+
+func TestOpenManifestSchema(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if version, err := manifestVersion(filepath.Join(dir, manifestName)); err != nil || version != manifestSchema {
+		t.Errorf("new manifest has version %d, %v, want %d", version, err, manifestSchema)
+	}
+
+	// A manifest of a newer version is not appended to
+	newer := t.TempDir()
+	if err := os.WriteFile(filepath.Join(newer, manifestName), []byte(`{"_schema":99}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := openManifest(newer); err == nil {
+		m.Close()
+		t.Error("openManifest appends to a manifest of a newer version")
+	}
+}
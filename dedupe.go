@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Content-addressed store shared by all creators. Files are kept once under
+// {sha256[0:2]}/{sha256} and linked into the directories of the creators.
+// A nil store keeps the files in the creators' directories, which is used when --dedupe-store is not set.
+type dedupeStore struct {
+	dir string
+}
+
+// Store of the run, set by --dedupe-store
+var contentStore *dedupeStore
+
+// Matches the SHA-256 the site names its data files after
+var contentHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Returns the path of the content with the hash in the store
+func (s *dedupeStore) path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+// Links the stored content of the URL into the file without downloading it,
+// reports whether the store already had the content
+func (s *dedupeStore) link(url string, file string) bool {
+	if s == nil {
+		return false
+	}
+
+	sum, ok := urlContentHash(url)
+	if !ok {
+		return false
+	}
+
+	stored := s.path(sum)
+	if _, err := os.Stat(stored); err != nil {
+		return false
+	}
+
+	if err := linkFile(stored, file); err != nil {
+		log.Printf("Failed to link %s from the dedupe store: %s", file, err)
+		return false
+	}
+	return true
+}
+
+// Files downloaded by this run by the content hash in their URL, so the same file of another creator,
+// such as the coomer mirror of a kemono creator, is linked instead of downloaded again
+var runFiles = struct {
+	sync.Mutex
+	paths map[string]string
+}{paths: map[string]string{}}
+
+// Remembers the downloaded file for the other occurrences of its content in the run
+func rememberRunFile(url string, file string) {
+	sum, ok := urlContentHash(url)
+	if !ok {
+		return
+	}
+	runFiles.Lock()
+	runFiles.paths[sum] = file
+	runFiles.Unlock()
+}
+
+// Links the content of the URL downloaded earlier in the run into the file,
+// reports whether it was. Hardlinks fall back to copies, never to symlinks
+// which would break when the first creator's files are moved.
+func linkRunFile(url string, file string) bool {
+	sum, ok := urlContentHash(url)
+	if !ok {
+		return false
+	}
+	runFiles.Lock()
+	src, ok := runFiles.paths[sum]
+	runFiles.Unlock()
+	if !ok || src == file {
+		return false
+	}
+
+	// The first copy may have been encrypted or removed since
+	if _, err := os.Stat(src); err != nil {
+		return false
+	}
+	if err := os.Link(src, file); err != nil {
+		if err := copyFile(src, file); err != nil {
+			log.Printf("Failed to copy %s downloaded earlier in the run: %s", filepath.Base(src), err)
+			return false
+		}
+	}
+
+	log.Printf("Linked %s to %s downloaded earlier in the run", filepath.Base(file), src)
+	return true
+}
+
+// Returns the SHA-256 of the content of a data URL, the site names the files after it
+func urlContentHash(url string) (string, bool) {
+	name := hashedFileName(url)
+	sum := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+	return sum, contentHash.MatchString(sum)
+}
+
+// Moves a downloaded file into the store and links it back into its place
+func (s *dedupeStore) add(file string) error {
+	if s == nil {
+		return nil
+	}
+
+	sum, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+
+	stored := s.path(sum)
+	if _, err := os.Stat(stored); err == nil {
+		// Another creator already has the same content
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(stored), 0755); err != nil {
+			return err
+		}
+		if err := moveFile(file, stored); err != nil {
+			return err
+		}
+	}
+
+	return linkFile(stored, file)
+}
+
+// Links the file into dst with a hardlink, a symlink on filesystems without
+// hardlinks, or a copy as the last resort
+func linkFile(src string, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	if abs, err := filepath.Abs(src); err == nil {
+		if err := os.Symlink(abs, dst); err == nil {
+			return nil
+		}
+	}
+
+	return copyFile(src, dst)
+}
+
+// Renames the file, copying it when the destination is on another filesystem
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Copies the content of a file into a new file
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
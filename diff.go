@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// A post with files missing locally
+type changedPost struct {
+	PostID string   `json:"post_id"`
+	URL    string   `json:"url"`
+	Files  []string `json:"files"`
+}
+
+// Changes of a creator on the site since the previous run, collected by --diff
+type changeset struct {
+	local map[string][]string
+
+	Creator  string        `json:"creator"`
+	Site     string        `json:"site"`
+	Service  string        `json:"service"`
+	User     string        `json:"user"`
+	New      []changedPost `json:"new_posts"`
+	Edited   []changedPost `json:"edited_posts"`
+	Deleted  []string      `json:"deleted_posts"`
+	NewBytes int64         `json:"new_bytes"`
+}
+
+// Starts the changeset of a creator from the files downloaded by the previous runs
+func newChangeset(profile profileConfig, name string, dir string, prefix string) (*changeset, error) {
+	local, err := localPosts(dir, prefix)
+	if os.IsNotExist(err) {
+		local, err = map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &changeset{
+		local:   local,
+		Creator: name,
+		Site:    profile.Site,
+		Service: profile.Service,
+		User:    profile.User,
+		New:     []changedPost{},
+		Edited:  []changedPost{},
+		Deleted: []string{},
+	}, nil
+}
+
+// Records the files of a post missing locally, the post is new when none of its files were downloaded
+func (c *changeset) add(post post, files []string) {
+	if c == nil || len(files) == 0 {
+		return
+	}
+	change := changedPost{PostID: post.id, URL: post.url, Files: files}
+	if len(c.local[post.id]) == 0 {
+		c.New = append(c.New, change)
+	} else {
+		c.Edited = append(c.Edited, change)
+	}
+}
+
+// Records the downloaded posts which no longer exist on the site and the size of the new files
+func (c *changeset) finish(seen map[string]bool, notFound []string, complete bool, newBytes int64) {
+	if c == nil {
+		return
+	}
+
+	for id := range removedPostIDs(c.local, seen, notFound, complete) {
+		if len(c.local[id]) > 0 {
+			c.Deleted = append(c.Deleted, id)
+		}
+	}
+	sort.Strings(c.Deleted)
+	c.NewBytes = newBytes
+}
+
+// Prints the changeset, as a single JSON line on the standard output with --diff-json
+func (c *changeset) print(asJSON bool) error {
+	if c == nil {
+		return nil
+	}
+
+	if asJSON {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	log.Printf("Changes of %s: %d new posts, %d edited posts, %d posts deleted upstream, %s of new files", c.Creator, len(c.New), len(c.Edited), len(c.Deleted), formatSize(c.NewBytes))
+	for _, post := range c.Edited {
+		log.Printf("  %s: %d files added", post.URL, len(post.Files))
+	}
+	for _, id := range c.Deleted {
+		log.Printf("  post %s deleted", id)
+	}
+	return nil
+}
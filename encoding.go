@@ -0,0 +1,46 @@
+package main
+
+import (
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transcodes the names and titles in legacy encodings to UTF-8, set by --fix-encoding
+var fixEncoding bool
+
+// Legacy encodings tried in order on text that is not valid UTF-8, Shift-JIS first as most
+// mojibake comes from Japanese services
+var legacyEncodings = []encoding.Encoding{
+	japanese.ShiftJIS,
+	japanese.EUCJP,
+	simplifiedchinese.GBK,
+}
+
+// Returns the text as valid UTF-8. Text in a legacy encoding is transcoded with --fix-encoding,
+// the remaining invalid byte sequences are replaced with U+FFFD so they never reach a file name.
+func validText(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+
+	if fixEncoding {
+		if decoded, ok := decodeLegacy(text); ok {
+			return decoded
+		}
+	}
+	return strings.ToValidUTF8(text, "�")
+}
+
+// Decodes the text with the first legacy encoding that reads all of it
+func decodeLegacy(text string) (string, bool) {
+	for _, enc := range legacyEncodings {
+		decoded, err := enc.NewDecoder().String(text)
+		if err == nil && utf8.ValidString(decoded) && !strings.ContainsRune(decoded, utf8.RuneError) {
+			return decoded, true
+		}
+	}
+	return "", false
+}
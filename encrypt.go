@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Suffix of the files encrypted with --encrypt-key
+const encryptedSuffix = ".enc"
+
+// Start of every encrypted file, followed by the nonce prefix and the sealed chunks
+const encryptedMagic = "KDLENC1\n"
+
+// Size of the plaintext chunks sealed separately, so files are encrypted and decrypted as streams
+const encryptedChunkSize = 64 << 10
+
+// Size of the random part of the chunk nonces, the rest is the chunk counter and the last chunk flag
+const noncePrefixSize = 7
+
+// Key of --encrypt-key, downloaded files are only stored encrypted when it is set
+var encryptionKey cipher.AEAD
+
+// File the key of --encrypt-key was read from, kept out of the archive browser
+var encryptionKeyFile string
+
+// Reads the hex encoded 256-bit key from the key file, generating a new key when the file does not exist
+func loadEncryptionKey(file string) (cipher.AEAD, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		data = make([]byte, 32)
+		if _, err := rand.Read(data); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(data) + "\n")
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Generated a new encryption key in %s, keep a copy of it, the files cannot be decrypted without it", file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s does not contain a hex encoded 256-bit key", file)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Reports whether the file was encrypted with --encrypt-key
+func isEncrypted(name string) bool {
+	return strings.HasSuffix(name, encryptedSuffix)
+}
+
+// Returns the name of the file before it was encrypted
+func plainName(name string) string {
+	return strings.TrimSuffix(name, encryptedSuffix)
+}
+
+// Refuses to work with an encrypted creator directory without the key
+func checkEncrypted(dir string, local map[string][]string) error {
+	if encryptionKey != nil {
+		return nil
+	}
+	for _, files := range local {
+		for _, name := range files {
+			if isEncrypted(name) {
+				return fmt.Errorf("%s contains encrypted files, pass the --encrypt-key they were encrypted with", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the nonce of a chunk, the last chunk is marked so a truncated file does not decrypt
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// Downloads the file encrypting it on the way into the file with the encrypted suffix, so its plaintext
+// never reaches the disk, returns the size of the plaintext. The unfinished download is encrypted as
+// well and starts over after a failure.
+func downloadEncrypted(ctx context.Context, url string, file string) (int64, error) {
+	partial := partialPath(url, file) + encryptedSuffix
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return 0, err
+	}
+
+	res, err := doRequest(ctx, http.MethodGet, url, http.Header{"Accept-Encoding": {"identity"}})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	out, err := os.Create(partial)
+	if err != nil {
+		return 0, err
+	}
+
+	// Checks the plaintext against its length and the hash in its URL while it is encrypted
+	var received atomic.Int64
+	hash := sha256.New()
+	err = encrypt(out, io.TeeReader(&countingBody{ReadCloser: res.Body, count: &received}, hash))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && res.ContentLength >= 0 && received.Load() != res.ContentLength {
+		err = fmt.Errorf("%s: downloaded %d of %d bytes", url, received.Load(), res.ContentLength)
+	}
+	if want, ok := urlContentHash(url); err == nil && ok && hex.EncodeToString(hash.Sum(nil)) != want {
+		err = fmt.Errorf("%s: the downloaded file does not match its hash", url)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return 0, err
+	}
+
+	return received.Load(), moveFile(partial, file+encryptedSuffix)
+}
+
+// Writes the plaintext read from r into w as sealed chunks
+func encrypt(w io.Writer, r io.Reader) error {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(encryptedMagic)
+	bw.Write(prefix)
+
+	// Reads one byte ahead to know which chunk is the last one
+	br := bufio.NewReaderSize(r, encryptedChunkSize+1)
+	chunk := make([]byte, encryptedChunkSize)
+	var sealed []byte
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, err = br.Peek(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		last := err == io.EOF
+
+		sealed = encryptionKey.Seal(sealed[:0], chunkNonce(prefix, counter, last), chunk[:n], nil)
+		if _, err := bw.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return bw.Flush()
+		}
+	}
+}
+
+// Stream of the plaintext of an encrypted file
+type decryptingReader struct {
+	r       io.Reader
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// Returns a reader decrypting the content of an encrypted file
+func decryptReader(r io.Reader) (io.Reader, error) {
+	if encryptionKey == nil {
+		return nil, errors.New("the file is encrypted, pass --encrypt-key to read it")
+	}
+
+	header := make([]byte, len(encryptedMagic)+noncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, errors.New("not a file encrypted by kemono-dl")
+	}
+
+	return &decryptingReader{
+		r:      r,
+		prefix: header[len(encryptedMagic):],
+		chunk:  make([]byte, encryptedChunkSize+encryptionKey.Overhead()),
+		buf:    make([]byte, encryptedChunkSize),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(d.r, d.chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+
+		// Only the last chunk opens with the last chunk flag set
+		sealed := d.chunk[:n]
+		plain, err := encryptionKey.Open(d.buf[:0], chunkNonce(d.prefix, d.counter, false), sealed, nil)
+		if err != nil {
+			plain, err = encryptionKey.Open(d.buf[:0], chunkNonce(d.prefix, d.counter, true), sealed, nil)
+			if err != nil {
+				return 0, errors.New("the file is damaged or was encrypted with another key")
+			}
+			d.done = true
+		}
+		d.plain = plain
+		d.counter++
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// Decrypts encrypted files next to them without removing the encrypted files
+func decryptCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: kemono-dl --encrypt-key FILE decrypt FILE...")
+	}
+
+	for _, file := range args {
+		if !isEncrypted(file) {
+			return fmt.Errorf("%s does not have the %s suffix", file, encryptedSuffix)
+		}
+		if err := decryptFile(file, plainName(file)); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		log.Printf("Decrypted %s", filepath.Base(plainName(file)))
+	}
+
+	return nil
+}
+
+// Writes the plaintext of the encrypted file into dest
+func decryptFile(file string, dest string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r, err := decryptReader(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dest + ".partial")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dest+".partial", dest)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Sets a new encryption key for the test and removes it afterwards
+func testEncryptionKey(t *testing.T) {
+	t.Helper()
+	key, err := loadEncryptionKey(filepath.Join(t.TempDir(), "archive.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptionKey = key
+	t.Cleanup(func() {
+		encryptionKey = nil
+	})
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	testEncryptionKey(t)
+
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3*encryptedChunkSize + 5} {
+		plain := bytes.Repeat([]byte("kemono-dl "), size/10+1)[:size]
+		var sealed bytes.Buffer
+		if err := encrypt(&sealed, bytes.NewReader(plain)); err != nil {
+			t.Fatalf("encrypt of %d bytes: %s", size, err)
+		}
+		// Short plaintexts turn up in random ciphertext by chance
+		if size >= 16 && bytes.Contains(sealed.Bytes(), plain) {
+			t.Errorf("encrypt of %d bytes kept the plaintext", size)
+		}
+
+		r, err := decryptReader(bytes.NewReader(sealed.Bytes()))
+		if err != nil {
+			t.Fatalf("decryptReader of %d bytes: %s", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("round trip of %d bytes gave %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestDecryptDamaged(t *testing.T) {
+	testEncryptionKey(t)
+
+	plain := bytes.Repeat([]byte("x"), 2*encryptedChunkSize+100)
+	var sealed bytes.Buffer
+	if err := encrypt(&sealed, bytes.NewReader(plain)); err != nil {
+		t.Fatal(err)
+	}
+	header := len(encryptedMagic) + noncePrefixSize
+	chunk := encryptedChunkSize + encryptionKey.Overhead()
+
+	flipped := bytes.Clone(sealed.Bytes())
+	flipped[header+10] ^= 1
+	tests := map[string][]byte{
+		"flipped bit":        flipped,
+		"missing last chunk": sealed.Bytes()[:header+2*chunk],
+		"cut within a chunk": sealed.Bytes()[:header+chunk+100],
+		"missing magic":      sealed.Bytes()[1:],
+		"empty":              nil,
+	}
+	for name, data := range tests {
+		r, err := decryptReader(bytes.NewReader(data))
+		if err == nil {
+			_, err = io.ReadAll(r)
+		}
+		if err == nil {
+			t.Errorf("%s decrypted without an error", name)
+		}
+	}
+}
+
+func TestDownloadEncrypted(t *testing.T) {
+	testRun(t)
+	testEncryptionKey(t)
+	site := newMockSite(t, 2)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst, noManifest: true}
+
+	// A download cut off is started over by the next run
+	truncated := strings.Split(mockFile(mockPostID(0)+"-zip", "archive.zip"), "?")[0]
+	site.fail(truncated, mockResponse{status: http.StatusOK, body: string(mockContents[truncated]), truncate: true})
+	stats, err := downloadCreator(context.Background(), site.profile(), wd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 5 || stats.failures != 1 {
+		t.Fatalf("downloaded %d files with %d failures, want 5 and 1", stats.files, stats.failures)
+	}
+	if stats, err = downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil || stats.files != 1 {
+		t.Fatalf("second run downloaded %d files, %v, want 1", stats.files, err)
+	}
+
+	// No plaintext reached the disk, not even in the unfinished downloads
+	dir := filepath.Join(wd, "kemono", "patreon", "Bob [1]")
+	files := 0
+	err = filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() == profileName {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		for path, plain := range mockContents {
+			if bytes.Contains(content, plain[:64]) {
+				t.Errorf("%s holds the plaintext of %s", file, path)
+			}
+		}
+		if !isEncrypted(file) {
+			t.Errorf("%s is not encrypted", file)
+			return nil
+		}
+		files++
+
+		r, err := decryptReader(bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		plain, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		for _, want := range mockContents {
+			if bytes.Equal(plain, want) {
+				return nil
+			}
+		}
+		t.Errorf("%s does not decrypt to a file of the site", file)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 6 {
+		t.Errorf("found %d encrypted files, want 6", files)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Classes of failed requests, matched with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrRateLimited = errors.New("rate limited")
+	ErrServerError = errors.New("server error")
+	ErrChallenge   = errors.New("blocked by DDoS-Guard challenge")
+	ErrRemoved     = errors.New("removed from the site")
+	ErrDecode      = errors.New("could not decode response")
+	ErrStatus      = errors.New("unexpected status")
+)
+
+// Error of a failed request. Kind is one of the error classes above, the
+// remaining fields carry the details relevant to that class.
+type ResponseError struct {
+	Kind       error
+	URL        string
+	Status     int
+	RetryAfter time.Duration
+	Snippet    string
+	// File holding the whole body with --debug
+	BodyFile string
+	Err      error
+}
+
+// Maximum length of the body text included in errors, set by --error-body-limit
+var errorBodyLimit = 300
+
+// Directory the whole bodies of failed requests are saved into with --debug
+var debugDir string
+
+// Directory of the --debug bodies, in the current directory
+const debugDirName = ".kemono-dl-debug"
+
+// Amount of the body read to find its text, error pages start with long headers and scripts
+const snippetReadLimit = 64 << 10
+
+// Maximum size of a body saved with --debug
+const debugBodyLimit = 16 << 20
+
+var (
+	scriptPattern = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	tagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.URL, e.Kind)
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.Status)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Snippet != "" {
+		msg += fmt.Sprintf(": %q", e.Snippet)
+	}
+	if e.BodyFile != "" {
+		msg += fmt.Sprintf(" (body saved to %s)", e.BodyFile)
+	}
+	return msg
+}
+
+// Matches the error against its class
+func (e *ResponseError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Writer keeping only the first n bytes written to it
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		chunk := p
+		if len(chunk) > l.n {
+			chunk = chunk[:l.n]
+		}
+		l.n -= len(chunk)
+		if _, err := l.w.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Returns the readable text of a body, without markup and with the whitespace collapsed
+func bodyText(body []byte) string {
+	text := scriptPattern.ReplaceAllString(string(body), " ")
+	text = tagPattern.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// Returns the readable text of a body truncated to --error-body-limit
+func bodySnippet(body []byte) string {
+	text := bodyText(body)
+	if len(text) <= errorBodyLimit {
+		return text
+	}
+	cut := errorBodyLimit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…"
+}
+
+// Amount of a failed body worth reading, all of it with --debug
+func bodyReadLimit() int {
+	if debugDir != "" {
+		return debugBodyLimit
+	}
+	return snippetReadLimit
+}
+
+// Adds the text of the body to the error and saves the whole body with --debug
+func describeBody(err *ResponseError, body []byte) {
+	if errorBodyLimit > 0 {
+		err.Snippet = bodySnippet(body)
+	}
+	if debugDir == "" || len(body) == 0 {
+		return
+	}
+
+	if e := os.MkdirAll(debugDir, 0755); e != nil {
+		return
+	}
+	file, e := os.CreateTemp(debugDir, time.Now().Format("20060102-150405")+"-*.body")
+	if e != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s\n\n", err.URL)
+	if _, e := file.Write(body); e == nil {
+		err.BodyFile = file.Name()
+	}
+}
+
+// Classifies an unsuccessful response, returns nil for successful ones
+func checkResponse(res *http.Response) error {
+	if res.StatusCode < 400 {
+		return nil
+	}
+
+	err := &ResponseError{URL: res.Request.URL.String(), Status: res.StatusCode}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		err.Kind = ErrNotFound
+	case res.StatusCode == http.StatusTooManyRequests:
+		err.Kind = ErrRateLimited
+		err.RetryAfter = retryAfter(res.Header.Get("Retry-After"))
+	case isChallenge(res):
+		err.Kind = ErrChallenge
+	case removalNotice(res):
+		err.Kind = ErrRemoved
+	case res.StatusCode >= 500:
+		err.Kind = ErrServerError
+	default:
+		err.Kind = ErrStatus
+	}
+
+	return err
+}
+
+// Reports whether the response is a DDoS-Guard bot challenge
+func isChallenge(res *http.Response) bool {
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	return strings.Contains(strings.ToLower(res.Header.Get("Server")), "ddos-guard")
+}
+
+// Longest wait asked by a Retry-After header that is respected, longer ones come from broken clocks
+const maxRetryAfter = time.Hour
+
+// Parses the Retry-After header given either in seconds or as an HTTP date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		// Dates are given by the site's clock
+		wait = time.Until(date) - siteClockSkew()
+	}
+
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
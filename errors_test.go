@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Sets --error-body-limit and --debug for the test and restores them afterwards
+func testErrorBodies(t *testing.T, limit int, debug string) {
+	t.Helper()
+	previousLimit, previousDebug := errorBodyLimit, debugDir
+	errorBodyLimit, debugDir = limit, debug
+	t.Cleanup(func() {
+		errorBodyLimit, debugDir = previousLimit, previousDebug
+	})
+}
+
+func TestBodySnippet(t *testing.T) {
+	testErrorBodies(t, 20, "")
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"<html><body><h1>Bad\n  Gateway</h1></body></html>", "Bad Gateway"},
+		{"<script>var x = '<b>';</script><style>p {}</style><p>Error</p>", "Error"},
+		{"<p>Tom &amp; Jerry&#39;s</p>", "Tom & Jerry's"},
+		{"<p>" + strings.Repeat("a", 50) + "</p>", strings.Repeat("a", 20) + "…"},
+		// Multi-byte characters are never cut in half
+		{strings.Repeat("a", 19) + "éé", strings.Repeat("a", 19) + "…"},
+		{strings.Repeat("エ", 10), strings.Repeat("エ", 6) + "…"},
+	}
+
+	for _, test := range tests {
+		got := bodySnippet([]byte(test.body))
+		if got != test.want {
+			t.Errorf("bodySnippet(%q) = %q, want %q", test.body, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("bodySnippet(%q) = %q, not valid UTF-8", test.body, got)
+		}
+	}
+}
+
+func TestDescribeBody(t *testing.T) {
+	body := []byte("<html><head><script>" + strings.Repeat("x", 1<<20) + "</script></head><body><p>" + strings.Repeat("Service unavailable ", 1000) + "</p></body></html>")
+
+	// The error carries a short snippet of the text only
+	testErrorBodies(t, 300, "")
+	err := &ResponseError{Kind: ErrServerError, URL: "https://kemono.su/api", Status: 503}
+	describeBody(err, body)
+	if len(err.Snippet) > 300+len("…") || !strings.HasPrefix(err.Snippet, "Service unavailable") {
+		t.Errorf("snippet of %d bytes: %.40q…", len(err.Snippet), err.Snippet)
+	}
+	if err.BodyFile != "" {
+		t.Errorf("body saved to %s without --debug", err.BodyFile)
+	}
+
+	// --error-body-limit 0 leaves the body out
+	testErrorBodies(t, 0, "")
+	err = &ResponseError{Kind: ErrServerError, URL: "https://kemono.su/api", Status: 503}
+	describeBody(err, body)
+	if err.Snippet != "" {
+		t.Errorf("snippet %.40q with --error-body-limit 0", err.Snippet)
+	}
+
+	// --debug saves the whole body
+	testErrorBodies(t, 300, t.TempDir())
+	err = &ResponseError{Kind: ErrServerError, URL: "https://kemono.su/api", Status: 503}
+	describeBody(err, body)
+	saved, e := os.ReadFile(err.BodyFile)
+	if e != nil || !strings.HasSuffix(string(saved), string(body)) {
+		t.Errorf("saved body: %v", e)
+	}
+	if !strings.Contains(err.Error(), err.BodyFile) {
+		t.Errorf("error %q does not point to the saved body", err.Error())
+	}
+}
+
+func TestFetchDocumentErrorBody(t *testing.T) {
+	testRun(t)
+	testErrorBodies(t, 300, "")
+	site := newMockSite(t, 1)
+	page := "<html><body>" + strings.Repeat("<div>Internal error</div>", 100000) + "</body></html>"
+	for i := 0; i <= retryConfig.maxRetries; i++ {
+		site.fail("/patreon/user/1", mockResponse{status: http.StatusInternalServerError, body: page})
+	}
+
+	// A 2.5 MB error page is reduced to a short line
+	_, err := fetchDocument(context.Background(), site.URL+"/patreon/user/1")
+	if !errors.Is(err, ErrServerError) {
+		t.Fatalf("fetchDocument = %v, want a server error", err)
+	}
+	if len(err.Error()) > 500 || strings.Contains(err.Error(), "<div>") {
+		t.Errorf("error of %d bytes: %.100q…", len(err.Error()), err.Error())
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Name of the table of contents written at the root of exported archives
+const tableOfContentsName = "SHA256SUMS"
+
+// Writes the files of an export into an archive format
+type archiveWriter interface {
+	// Adds a file to the archive and returns the writer receiving its content
+	create(name string, info fs.FileInfo) (io.Writer, error)
+	// Adds a file with the given content to the archive
+	add(name string, content []byte) error
+	Close() error
+}
+
+// Archive writer producing a zip file
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) create(name string, info fs.FileInfo) (io.Writer, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	return a.w.CreateHeader(header)
+}
+
+func (a *zipArchive) add(name string, content []byte) error {
+	w, err := a.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+// Archive writer producing a zstd compressed tar file
+type tarArchive struct {
+	w *tar.Writer
+	z *zstd.Encoder
+}
+
+func (a *tarArchive) create(name string, info fs.FileInfo) (io.Writer, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	if err := a.w.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return a.w, nil
+}
+
+func (a *tarArchive) add(name string, content []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := a.w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.w.Write(content)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.z.Close()
+}
+
+// Runs the export command packaging a creator's directory into a single archive
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "zip", "Archive format, zip or tar.zst")
+	excludeMetadata := flags.Bool("exclude-metadata", false, "Leave out the manifest, the profile and the removed posts report")
+	excludeState := flags.Bool("exclude-state", false, "Leave out unfinished downloads and their control files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errors.New("usage: kemono-dl export [--format zip|tar.zst] [--exclude-metadata] [--exclude-state] DIR [OUTPUT]")
+	}
+	if *format != "zip" && *format != "tar.zst" {
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+
+	dir, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	output := flags.Arg(1)
+	if output == "" {
+		output = filepath.Base(dir) + "." + *format
+	}
+
+	// Writes into a .partial file which is only renamed once the export is complete
+	partial := output + ".partial"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+
+	err = exportDirectory(file, *format, dir, *excludeMetadata, *excludeState)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	if err := os.Rename(partial, output); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %s to %s", dir, output)
+	return nil
+}
+
+// Streams the files of the directory into an archive written to w
+func exportDirectory(w io.Writer, format string, dir string, excludeMetadata bool, excludeState bool) error {
+	var archive archiveWriter
+	if format == "zip" {
+		archive = &zipArchive{w: zip.NewWriter(w)}
+	} else {
+		z, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		archive = &tarArchive{w: tar.NewWriter(z), z: z}
+	}
+
+	root := filepath.Base(dir)
+	var toc strings.Builder
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && excludeState && entry.IsDir() && entry.Name() == partialDirName {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name := entry.Name()
+		if excludeMetadata && (name == manifestName || name == removedPostsName || name == profileName) {
+			return nil
+		}
+		if excludeState && (strings.HasSuffix(name, ".aria2") || externalIncomplete(path)) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		// The checksums of the directory are replaced by the table of contents of the archive
+		if rel == tableOfContentsName {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		dst, err := archive.create(root+"/"+rel, info)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		// Hashes the file while it is copied into the archive
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&toc, "%s  %s\n", hex.EncodeToString(hash.Sum(nil)), rel)
+		return nil
+	})
+	if err != nil {
+		archive.Close()
+		return err
+	}
+
+	if err := archive.add(root+"/"+tableOfContentsName, []byte(toc.String())); err != nil {
+		archive.Close()
+		return err
+	}
+
+	return archive.Close()
+}
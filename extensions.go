@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Extensions of the content types recognized in files named without an extension
+var contentExtensions = map[string]string{
+	"image/jpeg":                   ".jpg",
+	"image/png":                    ".png",
+	"image/gif":                    ".gif",
+	"image/webp":                   ".webp",
+	"image/bmp":                    ".bmp",
+	"video/mp4":                    ".mp4",
+	"video/webm":                   ".webm",
+	"video/avi":                    ".avi",
+	"audio/mpeg":                   ".mp3",
+	"audio/wave":                   ".wav",
+	"application/ogg":              ".ogg",
+	"application/pdf":              ".pdf",
+	"application/zip":              ".zip",
+	"application/x-rar-compressed": ".rar",
+	"application/x-7z-compressed":  ".7z",
+	"image/vnd.adobe.photoshop":    ".psd",
+}
+
+// Returns the content type of the start of a file, adding the formats the standard sniffer does not know
+func sniffContentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return "application/x-7z-compressed"
+	case bytes.HasPrefix(head, []byte("8BPS")):
+		return "image/vnd.adobe.photoshop"
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return contentType
+}
+
+// Returns the extension of the file's content, or an empty string when it is not recognized
+func contentExtension(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return contentExtensions[sniffContentType(head[:n])], nil
+}
+
+// Returns the extensions a file downloaded from the URL without an extension may have been given,
+// the extension of the name the site stores it under first
+func extensionCandidates(rawURL string) []string {
+	var candidates []string
+	for _, ext := range contentExtensions {
+		candidates = append(candidates, ext)
+	}
+	sort.Strings(candidates)
+	if ext := path.Ext(hashedFileName(rawURL)); ext != "" {
+		candidates = append([]string{ext}, candidates...)
+	}
+	return candidates
+}
+
+// Gives a downloaded file named without an extension the extension of the name the site
+// stores it under or of its content, returns the new path
+func addExtension(rawURL string, file string) (string, error) {
+	if filepath.Ext(file) != "" {
+		return file, nil
+	}
+
+	ext := path.Ext(hashedFileName(rawURL))
+	if ext == "" {
+		var err error
+		if ext, err = contentExtension(file); err != nil || ext == "" {
+			return file, err
+		}
+	}
+
+	if _, err := os.Stat(file + ext); err == nil {
+		return file, nil
+	}
+	if err := os.Rename(file, file+ext); err != nil {
+		return file, err
+	}
+	return file + ext, nil
+}
+
+// Gives the files of a creator directory named without an extension the extension of their content
+func fixExtensionsCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: kemono-dl fix-extensions DIR")
+	}
+	dir := args[0]
+
+	local, err := localPosts(dir, directoryPrefix(dir))
+	if err != nil {
+		return err
+	}
+
+	fixed, unknown := 0, 0
+	for _, files := range local {
+		for _, name := range files {
+			if filepath.Ext(name) != "" {
+				continue
+			}
+
+			file := filepath.Join(dir, name)
+			ext, err := contentExtension(file)
+			if err != nil {
+				return err
+			}
+			if ext == "" {
+				unknown++
+				continue
+			}
+			if _, err := os.Stat(file + ext); err == nil {
+				log.Printf("Skipping %s: %s already exists", name, name+ext)
+				continue
+			}
+			if err := os.Rename(file, file+ext); err != nil {
+				return err
+			}
+			fmt.Printf("%s -> %s\n", name, name+ext)
+			fixed++
+		}
+	}
+
+	log.Printf("Added the extension to %d files, %d files have content that was not recognized", fixed, unknown)
+	return nil
+}
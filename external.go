@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Descriptions of the aria2c exit codes
+var aria2Errors = map[int]string{
+	1:  "unknown error",
+	2:  "timeout",
+	3:  "resource was not found",
+	6:  "network problem",
+	7:  "unfinished downloads",
+	9:  "not enough disk space",
+	13: "file already exists",
+	19: "name resolution failed",
+	22: "bad HTTP response header",
+	23: "too many redirects",
+	24: "HTTP authorization failed",
+}
+
+// Checks that the external downloader is supported and present on the system
+func checkExternalDownloader(name string) error {
+	if name != "aria2c" {
+		return fmt.Errorf("unsupported external downloader %q", name)
+	}
+
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("external downloader %s was not found", name)
+	}
+
+	return nil
+}
+
+// Reports whether a file is large enough to be handed to the external downloader.
+// Files of unknown size are treated as large.
+func largeFile(ctx context.Context, url string, minSize int64) bool {
+	res, err := doRequest(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return true
+	}
+	res.Body.Close()
+
+	return res.ContentLength < 0 || res.ContentLength >= minSize
+}
+
+// Reports whether an unfinished external download of the file exists
+func externalIncomplete(file string) bool {
+	_, err := os.Stat(file + ".aria2")
+	return err == nil
+}
+
+// Returns the aria2c input file downloading the URL with the headers. The headers are passed
+// on the standard input so the cookies do not show up in the process list.
+func aria2Input(url string, header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var input strings.Builder
+	fmt.Fprintln(&input, url)
+	for _, name := range names {
+		for _, value := range header[name] {
+			// Headers cleared by the profile are left to aria2c
+			if value == "" {
+				continue
+			}
+			if name == "User-Agent" {
+				fmt.Fprintf(&input, "  user-agent=%s\n", value)
+			} else {
+				fmt.Fprintf(&input, "  header=%s: %s\n", name, value)
+			}
+		}
+	}
+	return input.String()
+}
+
+// Downloads a file with aria2c, sending the headers of the run like the built-in downloader.
+// The download starts once the active hours and the rate limit let it, the connections aria2c
+// opens for the file are not limited by --rate.
+func externalDownload(ctx context.Context, cfg *config, url string, file string) error {
+	args := []string{
+		"--dir=" + filepath.Dir(file),
+		"--out=" + filepath.Base(file),
+		"--continue=true",
+		"--auto-file-renaming=false",
+		"--allow-overwrite=true",
+		// Preallocates the file at once where the filesystem supports it, instead of writing it out first
+		"--file-allocation=falloc",
+		"--console-log-level=warn",
+		"--summary-interval=0",
+		"--input-file=-",
+	}
+	args = append(args, strings.Fields(cfg.externalDownloaderArgs)...)
+
+	if err := limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, cfg.externalDownloader, args...)
+	postURL, _ := ctx.Value(fileTransferKey{}).(string)
+	cmd.Stdin = strings.NewReader(aria2Input(url, profileHeaders(fileRequest, postURL)))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+
+	// Translates the exit code into a readable error
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if description, ok := aria2Errors[code]; ok {
+			return fmt.Errorf("%s exited with code %d: %s", cfg.externalDownloader, code, description)
+		}
+		return fmt.Errorf("%s exited with code %d", cfg.externalDownloader, code)
+	}
+
+	return err
+}
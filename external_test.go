@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Script standing in for aria2c, saves its input and writes the requested file
+const fakeAria2 = `#!/bin/sh
+cat > "$ARIA2_INPUT"
+for arg; do
+	case $arg in
+	--dir=*) dir=${arg#--dir=} ;;
+	--out=*) out=${arg#--out=} ;;
+	esac
+done
+echo downloaded > "$dir/$out"
+`
+
+func TestExternalDownloadHeaders(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake downloader is a shell script")
+	}
+	testRun(t)
+	if err := setHeaderProfile("browser", headerList{"Cookie: session=secret", "X-Token: abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	aria2 := filepath.Join(dir, "aria2c")
+	if err := os.WriteFile(aria2, []byte(fakeAria2), 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "input")
+	t.Setenv("ARIA2_INPUT", input)
+
+	cfg := &config{externalDownloader: aria2}
+	dest := filepath.Join(dir, "video.mp4")
+	ctx := withFileTransfer(context.Background(), "https://kemono.su/patreon/user/1/post/2")
+	if err := externalDownload(ctx, cfg, "https://kemono.su/data/video.mp4", dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same headers as the built-in downloader sends for the file
+	want := []string{
+		"https://kemono.su/data/video.mp4",
+		"  header=Accept: */*",
+		"  header=Accept-Language: en-US,en;q=0.9",
+		"  header=Cookie: session=secret",
+		"  header=Referer: https://kemono.su/patreon/user/1/post/2",
+		"  header=Sec-Fetch-Dest: empty",
+		"  header=Sec-Fetch-Mode: no-cors",
+		"  header=Sec-Fetch-Site: same-site",
+		"  user-agent=" + browserUserAgent,
+		"  header=X-Token: abc",
+	}
+	if strings.TrimSpace(string(got)) != strings.Join(want, "\n") {
+		t.Errorf("aria2c input:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
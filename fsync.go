@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Policies of flushing the downloaded files to the disk, set by --fsync
+const (
+	fsyncNever   = "never"
+	fsyncPerFile = "per-file"
+	fsyncPerPost = "per-post"
+)
+
+// Checks the --fsync value
+func checkFsync(policy string) error {
+	switch policy {
+	case fsyncNever, fsyncPerFile, fsyncPerPost:
+		return nil
+	}
+	return fmt.Errorf("unknown fsync policy %q, expected never, per-file or per-post", policy)
+}
+
+// Flushes the file or directory to the disk
+func syncPath(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Flushes a downloaded file with --fsync per-file, or keeps it for the end of the post with per-post
+func (c *creator) syncFile(file string) {
+	switch c.cfg.fsync {
+	case fsyncPerFile:
+		if err := syncPath(file); err != nil {
+			log.Printf("Failed to flush %s: %s", filepath.Base(file), err)
+		}
+		c.manifest.flush(true)
+		c.syncDirectory()
+	case fsyncPerPost:
+		c.unsynced = append(c.unsynced, file)
+	}
+}
+
+// Writes the manifest lines of the post and, with --fsync per-post, flushes the files downloaded for it
+func (c *creator) syncPost() {
+	c.manifest.flush(c.cfg.fsync != fsyncNever)
+	if c.cfg.fsync != fsyncPerPost || len(c.unsynced) == 0 {
+		return
+	}
+
+	for _, file := range c.unsynced {
+		if err := syncPath(file); err != nil {
+			log.Printf("Failed to flush %s: %s", filepath.Base(file), err)
+		}
+	}
+	c.unsynced = c.unsynced[:0]
+	c.syncDirectory()
+}
+
+// Flushes the entries of the creator's directory, so the new names survive a crash.
+// Directories cannot be flushed on every system, failures are not reported.
+func (c *creator) syncDirectory() {
+	syncPath(c.directory)
+}
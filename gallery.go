@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Directory in the creator's directory holding the per-post gallery pages
+const galleryDirName = "_gallery"
+
+// Number of posts on a single page of the gallery index
+const galleryPageSize = 60
+
+// Extensions of the files shown as images and videos in the gallery
+var (
+	imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true, ".avif": true}
+	videoExtensions = map[string]bool{".mp4": true, ".webm": true, ".m4v": true, ".mov": true, ".mkv": true}
+)
+
+// Matches the ID suffix of a creator directory
+var directoryIDSuffix = regexp.MustCompile(` \[[^\]]+\]$`)
+
+// A downloaded file shown in the gallery
+type galleryFile struct {
+	Name  string
+	Link  template.URL
+	Image bool
+	Video bool
+}
+
+// A post shown in the gallery
+type galleryPost struct {
+	ID        string
+	Link      template.URL
+	Thumbnail *galleryFile
+	Files     []galleryFile
+}
+
+// A page of the gallery index
+type galleryPage struct {
+	Title    string
+	Posts    []galleryPost
+	Page     int
+	Pages    int
+	Previous template.URL
+	Next     template.URL
+}
+
+// Shared styles of the gallery pages, embedded so the pages work offline
+const galleryStyle = `<style>
+body{font-family:sans-serif;margin:1em;background:#1d1f20;color:#ddd}
+a{color:#e8a17d}
+.grid{display:grid;grid-template-columns:repeat(auto-fill,minmax(180px,1fr));gap:1em}
+.post{background:#282a2b;padding:.5em;text-align:center}
+.post img{width:100%;height:160px;object-fit:cover}
+.placeholder{height:160px;display:flex;align-items:center;justify-content:center;background:#333}
+.media img,.media video{max-width:100%;display:block;margin:1em 0}
+nav{margin:1em 0}
+</style>`
+
+var galleryIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>` + galleryStyle + `</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="grid">
+{{range .Posts}}<div class="post"><a href="{{.Link}}">{{if .Thumbnail}}<img src="{{.Thumbnail.Link}}" loading="lazy" alt="">{{else}}<div class="placeholder">{{len .Files}} files</div>{{end}}<br>Post {{.ID}}</a></div>
+{{end}}</div>
+<nav>{{if .Previous}}<a href="{{.Previous}}">Previous</a> {{end}}Page {{.Page}} of {{.Pages}}{{if .Next}} <a href="{{.Next}}">Next</a>{{end}}</nav>
+</body></html>
+`))
+
+var galleryPostTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Post {{.ID}}</title>` + galleryStyle + `</head>
+<body>
+<a href="../index.html">Back to the index</a>
+<h1>Post {{.ID}}</h1>
+<div class="media">
+{{range .Files}}{{if .Image}}<a href="{{.Link}}"><img src="{{.Link}}" loading="lazy" alt="{{.Name}}"></a>
+{{else if .Video}}<video src="{{.Link}}" controls preload="metadata"></video>
+{{else}}<p><a href="{{.Link}}">{{.Name}}</a></p>
+{{end}}{{end}}</div>
+</body></html>
+`))
+
+// Returns the prefix of the files in a creator directory named "{name} [{id}]" or "{name}"
+func directoryPrefix(dir string) string {
+	return directoryIDSuffix.ReplaceAllString(filepath.Base(dir), "")
+}
+
+// Returns the IDs of the downloaded posts, newest first as post IDs grow over time
+func newestPosts(local map[string][]string) []string {
+	ids := make([]string, 0, len(local))
+	for id := range local {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.ParseInt(ids[i], 10, 64)
+		b, errB := strconv.ParseInt(ids[j], 10, 64)
+		if errA == nil && errB == nil {
+			return a > b
+		}
+		return ids[i] > ids[j]
+	})
+	return ids
+}
+
+// Generates index.html in the creator's directory with a paginated grid of the
+// downloaded posts, and a page for every post embedding its media files.
+// All links are relative so the gallery works offline and after moving the directory.
+func generateGallery(dir string, prefix string, title string) error {
+	local, err := localPosts(dir, prefix)
+	if err != nil {
+		return err
+	}
+	if err := checkEncrypted(dir, local); err != nil {
+		return err
+	}
+
+	ids := newestPosts(local)
+
+	if err := os.MkdirAll(filepath.Join(dir, galleryDirName), 0755); err != nil {
+		return err
+	}
+
+	var posts []galleryPost
+	for _, id := range ids {
+		files := local[id]
+		sort.Strings(files)
+
+		post := galleryPost{ID: id, Link: template.URL(galleryDirName + "/" + url.PathEscape(id) + ".html")}
+		for _, name := range files {
+			// Encrypted files are linked by their plaintext name, serve decrypts them
+			name = plainName(name)
+			ext := strings.ToLower(filepath.Ext(name))
+			file := galleryFile{
+				Name:  name,
+				Link:  template.URL("../" + url.PathEscape(name)),
+				Image: imageExtensions[ext],
+				Video: videoExtensions[ext],
+			}
+			post.Files = append(post.Files, file)
+			if file.Image && post.Thumbnail == nil {
+				thumbnail := file
+				thumbnail.Link = template.URL(url.PathEscape(name))
+				post.Thumbnail = &thumbnail
+			}
+		}
+
+		if err := writeTemplate(filepath.Join(dir, galleryDirName, id+".html"), galleryPostTemplate, post); err != nil {
+			return err
+		}
+		posts = append(posts, post)
+	}
+
+	pages := (len(posts) + galleryPageSize - 1) / galleryPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	for page := 1; page <= pages; page++ {
+		start := (page - 1) * galleryPageSize
+		end := start + galleryPageSize
+		if end > len(posts) {
+			end = len(posts)
+		}
+
+		data := galleryPage{Title: title, Posts: posts[start:end], Page: page, Pages: pages}
+		if page > 1 {
+			data.Previous = template.URL(galleryPageName(page - 1))
+		}
+		if page < pages {
+			data.Next = template.URL(galleryPageName(page + 1))
+		}
+
+		if err := writeTemplate(filepath.Join(dir, galleryPageName(page)), galleryIndexTemplate, data); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Generated the gallery of %d posts in %s", len(posts), filepath.Join(dir, "index.html"))
+	return nil
+}
+
+// Returns the file name of a gallery index page
+func galleryPageName(page int) string {
+	if page == 1 {
+		return "index.html"
+	}
+	return fmt.Sprintf("index-%d.html", page)
+}
+
+// Renders the template into a file
+func writeTemplate(file string, tmpl *template.Template, data interface{}) error {
+	var content bytes.Buffer
+	if err := tmpl.Execute(&content, data); err != nil {
+		return err
+	}
+
+	_, err := writeMetadata(file, content.Bytes())
+	return err
+}
+
+// Runs the index command generating the gallery of an existing creator directory
+func indexCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: kemono-dl index DIR")
+	}
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	prefix := directoryPrefix(dir)
+	return generateGallery(dir, prefix, prefix)
+}
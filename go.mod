@@ -4,7 +4,8 @@ go 1.20
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
-	github.com/cavaliergopher/grab/v3 v3.0.1
+	github.com/klauspost/compress v1.17.4
+	golang.org/x/text v0.21.0
 )
 
 require (
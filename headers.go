@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of requests sent with different headers
+const (
+	pageRequest = iota
+	fileRequest
+)
+
+// Headers sent with each kind of request
+type headerProfile struct {
+	headers [2]http.Header
+	// Sends the post page as the referer of its files
+	referer bool
+}
+
+// User agent of a current desktop browser used by the browser profile
+const browserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// Header profiles selectable with --header-profile
+var headerProfiles = map[string]headerProfile{
+	// Identifies the program, only asks for the content it expects
+	"minimal": {headers: [2]http.Header{
+		pageRequest: {
+			"User-Agent": {"kemono-dl"},
+			"Accept":     {"text/html"},
+		},
+		fileRequest: {
+			"User-Agent": {"kemono-dl"},
+			"Accept":     {"*/*"},
+		},
+	}, referer: true},
+	// Looks like a browser navigating to the pages and following the links to the files
+	"browser": {headers: [2]http.Header{
+		pageRequest: {
+			"User-Agent":      {browserUserAgent},
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"document"},
+			"Sec-Fetch-Mode":  {"navigate"},
+			"Sec-Fetch-Site":  {"same-origin"},
+			"Sec-Fetch-User":  {"?1"},
+		},
+		fileRequest: {
+			"User-Agent":      {browserUserAgent},
+			"Accept":          {"*/*"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"empty"},
+			"Sec-Fetch-Mode":  {"no-cors"},
+			"Sec-Fetch-Site":  {"same-site"},
+		},
+	}, referer: true},
+	// Only sends the headers given with --header
+	"custom": {headers: [2]http.Header{
+		pageRequest: {"User-Agent": {""}},
+		fileRequest: {"User-Agent": {""}},
+	}},
+}
+
+// Headers of the run, set by --header-profile and --header
+var requestHeaders = struct {
+	profile headerProfile
+	extra   http.Header
+}{profile: headerProfiles["minimal"], extra: http.Header{}}
+
+// Parses the --header values, e.g. "Cookie: session=..."
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q, expected 'Name: value'", value)
+	}
+	*h = append(*h, strings.TrimSpace(name)+": "+strings.TrimSpace(val))
+	return nil
+}
+
+// Selects the header profile and the additional headers of the run
+func setHeaderProfile(name string, extra headerList) error {
+	profile, ok := headerProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown header profile %q, expected browser, minimal or custom", name)
+	}
+	requestHeaders.profile = profile
+
+	for _, header := range extra {
+		name, value, _ := strings.Cut(header, ": ")
+		requestHeaders.extra.Add(name, value)
+	}
+	return nil
+}
+
+// Sets the headers of the profile and the --header values on the requests,
+// file downloads are sent with their post as the referer
+func setHeaders(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		kind := pageRequest
+		postURL, file := fileTransfer(req)
+		if file {
+			kind = fileRequest
+		}
+
+		req = req.Clone(req.Context())
+		for name, values := range profileHeaders(kind, postURL) {
+			req.Header[name] = values
+		}
+
+		return next(req)
+	}
+}
+
+// Returns the headers of the profile and the --header values for a kind of request,
+// files are sent with their post as the referer
+func profileHeaders(kind int, postURL string) http.Header {
+	header := http.Header{}
+	for name, values := range requestHeaders.profile.headers[kind] {
+		header[name] = values
+	}
+	if kind == fileRequest && postURL != "" && requestHeaders.profile.referer {
+		header.Set("Referer", postURL)
+	}
+	for name, values := range requestHeaders.extra {
+		header[name] = values
+	}
+	return header
+}
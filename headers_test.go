@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeaderProfiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		page    http.Header
+		file    http.Header
+	}{
+		{"minimal", http.Header{
+			"User-Agent": {"kemono-dl"},
+			"Accept":     {"text/html"},
+		}, http.Header{
+			"User-Agent": {"kemono-dl"},
+			"Accept":     {"*/*"},
+			"Referer":    {"{post}"},
+		}},
+		{"browser", http.Header{
+			"User-Agent":      {browserUserAgent},
+			"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"document"},
+			"Sec-Fetch-Mode":  {"navigate"},
+			"Sec-Fetch-Site":  {"same-origin"},
+			"Sec-Fetch-User":  {"?1"},
+		}, http.Header{
+			"User-Agent":      {browserUserAgent},
+			"Accept":          {"*/*"},
+			"Accept-Language": {"en-US,en;q=0.9"},
+			"Sec-Fetch-Dest":  {"empty"},
+			"Sec-Fetch-Mode":  {"no-cors"},
+			"Sec-Fetch-Site":  {"same-site"},
+			"Referer":         {"{post}"},
+		}},
+		{"custom", http.Header{}, http.Header{}},
+	}
+
+	// Headers sent by the transport rather than the profile
+	transport := map[string]bool{"Accept-Encoding": true, "Range": true, "If-Range": true}
+
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			testRun(t)
+			requestHeaders.extra = http.Header{}
+			if err := setHeaderProfile(test.profile, headerList{"X-Token: abc"}); err != nil {
+				t.Fatal(err)
+			}
+			site := newMockSite(t, 1)
+			post := site.URL + "/patreon/user/1/post/" + mockPostID(0)
+
+			cfg := &config{order: orderNewestFirst, noManifest: true}
+			if _, err := downloadCreator(context.Background(), site.profile(), t.TempDir(), cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			site.mu.Lock()
+			defer site.mu.Unlock()
+			for i, request := range site.requests {
+				want := test.page
+				if strings.HasPrefix(request, "/data/") {
+					want = test.file
+				}
+				want = want.Clone()
+				want.Set("X-Token", "abc")
+				if referer := want.Get("Referer"); referer != "" {
+					want.Set("Referer", strings.Replace(referer, "{post}", post, 1))
+				}
+
+				got := site.headers[i]
+				for name := range got {
+					if !transport[name] && want.Get(name) == "" {
+						t.Errorf("%s sent %s: %s, not in the profile", request, name, got.Get(name))
+					}
+				}
+				for name := range want {
+					if got.Get(name) != want.Get(name) {
+						t.Errorf("%s sent %s: %q, want %q", request, name, got.Get(name), want.Get(name))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runs a user-supplied hook command with the placeholder replaced by the value.
+// The command is split on whitespace and executed directly, without a shell,
+// so paths containing spaces are passed as a single argument.
+func runHook(command string, placeholder string, value string, timeout time.Duration) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return errors.New("empty hook command")
+	}
+
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, placeholder, value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Captures both stdout and stderr of the command into the log
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		log.Printf("[%s] %s", args[0], scanner.Text())
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return err
+}
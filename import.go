@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// Header of SQLite databases such as the gallery-dl download archive
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Matches the URLs in the imported files
+var importURL = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// Prints the creator URLs found in gallery-dl configs and URL lists of other downloaders,
+// one per line so the output can be passed back as arguments
+func importCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: kemono-dl import FILE...")
+	}
+
+	found := map[string]string{}
+	for _, file := range args {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(data, sqliteHeader) {
+			log.Printf("Skipping %s: download archives are not supported, the posts are skipped by their existing files instead", file)
+			continue
+		}
+
+		// gallery-dl configs are JSON with the URLs anywhere in their values
+		texts := []string{string(data)}
+		var config any
+		if json.Unmarshal(data, &config) == nil {
+			texts = jsonStrings(config, nil)
+		}
+
+		count := 0
+		for _, text := range texts {
+			for _, raw := range importURL.FindAllString(text, -1) {
+				profile, err := parseProfileURL(raw)
+				if err != nil {
+					continue
+				}
+				// Mirrors of the same site share a creator's directory, the first URL is kept
+				key := profile.Site + "/" + profile.Service + "/" + profile.User
+				if _, ok := found[key]; !ok {
+					found[key] = profile.URL()
+					count++
+				}
+			}
+		}
+		log.Printf("Imported %d creators from %s", count, file)
+	}
+
+	urls := make([]string, 0, len(found))
+	for _, url := range found {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		fmt.Println(url)
+	}
+
+	log.Printf("Imported %d creators in total", len(urls))
+	return nil
+}
+
+// Collects all strings in a decoded JSON value
+func jsonStrings(value any, out []string) []string {
+	switch v := value.(type) {
+	case string:
+		out = append(out, v)
+	case []any:
+		for _, item := range v {
+			out = jsonStrings(item, out)
+		}
+	case map[string]any:
+		for _, item := range v {
+			out = jsonStrings(item, out)
+		}
+	}
+	return out
+}
@@ -1,49 +1,361 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/cavaliergopher/grab/v3"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"path"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// Holds the options of a download run
+type config struct {
+	notifyDesktop bool
+	execAfterFile string
+	execAfterPost string
+	execTimeout   time.Duration
+	execStrict    bool
+
+	externalDownloader     string
+	externalDownloaderArgs string
+	externalMinSize        int64
+
+	noManifest  bool
+	metricsAddr string
+	apiCache    time.Duration
+	noCache     bool
+	rate        float64
+	watch       bool
+	interval    time.Duration
+	pruneReport bool
+	pruneDelete bool
+	checkOnly   bool
+	maxDuration time.Duration
+	maxBytes    byteSize
+	htmlIndex   bool
+	dedupeStore string
+	xattr       bool
+	postWorkers int
+	noSnapshot  bool
+	playlist    bool
+	sums        bool
+
+	monthlyBudget  byteSize
+	romanize       bool
+	fixEncoding    bool
+	listServices   bool
+	listRemoved    bool
+	recheckRemoved bool
+	chaos          string
+
+	headerProfile string
+	headers       headerList
+
+	diff     bool
+	diffJSON bool
+
+	encryptKey string
+	order      string
+	skipStubs  bool
+
+	indexFiles   bool
+	noIndexFiles bool
+
+	activeHours     string
+	metadataAnytime bool
+
+	service string
+	user    string
+	site    string
+
+	redactParams string
+	redactPaths  bool
+	debugBundle  string
+
+	errorBodyLimit int
+	debug          bool
+
+	maxRetries      int
+	retryBackoff    time.Duration
+	retryMultiplier float64
+	maxRetryWait    time.Duration
+	retryPool       int
+
+	preferSize string
+	chunks     int
+
+	ignorePatterns  patternList
+	includePatterns patternList
+
+	fsync string
+
+	keepMetadataHistory int
+	partialMaxAge       time.Duration
+}
+
+// Holds the state of downloading a single creator
+type creator struct {
+	name      string
+	prefix    string
+	site      string
+	service   string
+	baseURL   string
+	directory string
+	cfg       *config
+	stats     *summary
+	manifest  *manifest
+	snapshot  *dirSnapshot
+	changes   *changeset
+	indexed   bool
+	// Files taken down from the site, by URL, skipped without a request
+	removed map[string]manifestEntry
+	// Name patterns of the files left out
+	filter nameFilter
+	// Files downloaded for the current post, flushed to the disk at its end with --fsync per-post
+	unsynced []string
+}
+
+// Holds the statistics of a single download run
+type summary struct {
+	files        int
+	failures     int
+	hookFailures int
+
+	missingPosts int
+	missingFiles int
+	missingBytes int64
+
+	stubs   int
+	removed int
+	// Listed posts whose page was gone by the time it was fetched
+	removedPosts int
+	// Files left out by the name patterns
+	ignored int
+}
+
 func main() {
-	// Checks if URL was provided as an argument
-	if len(os.Args) < 2 {
-		log.Fatal("Please provide a url")
+	var cfg config
+	flag.BoolVar(&cfg.notifyDesktop, "notify-desktop", false, "Show a desktop notification with the totals when the run finishes or fails, after every check in watch mode")
+	flag.StringVar(&cfg.execAfterFile, "exec-after-file", "", "Command to run after each downloaded file, {} is replaced with the file path")
+	flag.StringVar(&cfg.execAfterPost, "exec-after-post", "", "Command to run after each post, {dir} is replaced with the download directory")
+	flag.DurationVar(&cfg.execTimeout, "exec-timeout", 5*time.Minute, "Maximum run time of a single hook command")
+	flag.BoolVar(&cfg.execStrict, "exec-strict", false, "Treat failing hook commands as failed downloads")
+	flag.StringVar(&cfg.externalDownloader, "external-downloader", "", "External program used to download files (supported: aria2c)")
+	flag.StringVar(&cfg.externalDownloaderArgs, "external-downloader-args", "", "Additional arguments passed to the external downloader")
+	flag.Int64Var(&cfg.externalMinSize, "external-downloader-min-size", 10<<20, "Files smaller than this many bytes use the built-in downloader")
+	flag.BoolVar(&cfg.noManifest, "no-manifest", false, "Do not record downloaded files in manifest.jsonl")
+	flag.StringVar(&cfg.metricsAddr, "metrics-addr", "", "Address serving Prometheus metrics on /metrics and a health check on /healthz")
+	flag.DurationVar(&cfg.apiCache, "api-cache", 0, "Cache fetched pages on disk for this long, e.g. 1h")
+	flag.BoolVar(&cfg.noCache, "no-cache", false, "Disable the page cache")
+	flag.Float64Var(&cfg.rate, "rate", 3, "Maximum number of requests per second sent to the site, 0 for no limit")
+	flag.BoolVar(&cfg.watch, "watch", false, "Keep running and check the creators for new posts periodically")
+	flag.DurationVar(&cfg.interval, "interval", 6*time.Hour, "Time between the checks in watch mode")
+	flag.BoolVar(&cfg.pruneReport, "prune-report", false, "Write removed_posts.json listing downloaded posts that no longer exist on the site")
+	flag.BoolVar(&cfg.pruneDelete, "prune-delete", false, "Move the files of removed posts into the _removed directory, implies --prune-report")
+	flag.BoolVar(&cfg.checkOnly, "check-only", false, "Report the posts and files missing locally without downloading anything, exits with 2 when content is missing")
+	flag.DurationVar(&cfg.maxDuration, "max-duration", 0, "Stop starting new files after this long, e.g. 5h")
+	flag.Var(&cfg.maxBytes, "max-bytes", "Stop starting new files after downloading this much, e.g. 20G")
+	flag.BoolVar(&cfg.htmlIndex, "html-index", false, "Generate an offline HTML gallery in the creator's directory")
+	flag.StringVar(&cfg.dedupeStore, "dedupe-store", "", "Directory storing the content of all files once, linked into the creators' directories")
+	flag.BoolVar(&cfg.xattr, "xattr", false, "Record the source URLs and hash of downloaded files in their extended attributes")
+	flag.IntVar(&cfg.postWorkers, "post-workers", 3, "Number of post pages fetched concurrently, all requests still share the rate limit")
+	flag.BoolVar(&cfg.noSnapshot, "no-snapshot", false, "Check every file on disk instead of listing the creator's directory once, for archives changed by other programs during the run")
+	flag.BoolVar(&cfg.playlist, "playlist", false, "Generate playlist.m3u8 of the downloaded videos in the creator's directory")
+	flag.BoolVar(&cfg.sums, "sums", false, "Keep SHA256SUMS of the downloaded files in the creator's directory, for rclone check --checkfile")
+	flag.Var(&cfg.monthlyBudget, "monthly-budget", "Refuse to start, or stop starting new files, once this much was transferred in the calendar month, e.g. 500G")
+	flag.BoolVar(&cfg.romanize, "romanize-filenames", false, "Transliterate non-ASCII file names to ASCII, the original names are kept in the manifest")
+	flag.BoolVar(&cfg.fixEncoding, "fix-encoding", false, "Transcode file names and titles in Shift-JIS, EUC-JP or GBK to UTF-8 instead of replacing their invalid bytes")
+	flag.BoolVar(&cfg.listServices, "list-services", false, "Print the known services with their quirks and exit")
+	flag.BoolVar(&cfg.listRemoved, "list-removed", false, "Print the files taken down from the site of every creator archived in the current directory and exit")
+	flag.BoolVar(&cfg.recheckRemoved, "recheck-removed", false, "Request the files recorded as taken down from the site again, in case they were restored")
+	flag.BoolVar(&cfg.diff, "diff", false, "Print the new, edited and deleted posts since the previous run without downloading anything, implies --check-only")
+	flag.BoolVar(&cfg.diffJSON, "diff-json", false, "Print the changes of --diff as a JSON line per creator on the standard output, implies --diff")
+	flag.StringVar(&cfg.encryptKey, "encrypt-key", "", "Store the downloaded files encrypted with the key in this file, a new key is generated when it does not exist")
+	flag.StringVar(&cfg.order, "order", orderNewestFirst, "Order the files are downloaded in: newest-first, oldest-first, smallest-first or largest-first")
+	flag.BoolVar(&cfg.skipStubs, "skip-stubs", false, "Do not download coomer videos smaller than 1 MiB, which are likely preview stubs")
+	flag.StringVar(&cfg.activeHours, "active-hours", "", "Only download files in this daily window, e.g. 02:00-08:00, waiting for it to open otherwise")
+	flag.BoolVar(&cfg.metadataAnytime, "metadata-anytime", false, "Keep fetching the creators' pages outside --active-hours, only the file downloads wait")
+	flag.BoolVar(&cfg.indexFiles, "index-files", false, "Prefix new files with their position in the post, 00 for the main file, as new archives do")
+	flag.BoolVar(&cfg.noIndexFiles, "no-index-files", false, "Do not prefix the files of new archives with their position in the post")
+	flag.StringVar(&cfg.service, "service", "", "Service of the creator to download instead of a url, e.g. patreon")
+	flag.StringVar(&cfg.user, "user", "", "ID of the creator on the --service")
+	flag.StringVar(&cfg.site, "site", "", "Site or domain to download the --service creator from, e.g. kemono.su (default: the site mirroring the service)")
+	flag.StringVar(&cfg.redactParams, "redact-params", defaultRedactedParams, "Comma-separated query parameters masked in the log, the --header values are always masked")
+	flag.BoolVar(&cfg.redactPaths, "redact-paths", false, "Shorten the local paths in the log relative to the current and home directory")
+	flag.StringVar(&cfg.debugBundle, "debug-bundle", "", "Write a zip with the redacted log, the options and the summary of the run to this file, for bug reports")
+	flag.IntVar(&cfg.errorBodyLimit, "error-body-limit", 300, "Maximum length of the response text included in request errors, 0 to leave it out")
+	flag.BoolVar(&cfg.debug, "debug", false, "Save the whole responses of failed requests into "+debugDirName+" in the current directory")
+	flag.IntVar(&cfg.maxRetries, "max-retries", maxRetries, "Maximum number of retries of a single failed request")
+	flag.DurationVar(&cfg.retryBackoff, "retry-backoff", retryBackoff, "Delay before the first retry of a failed request")
+	flag.Float64Var(&cfg.retryMultiplier, "retry-multiplier", retryMultiplier, "Factor the delay grows by after every retry")
+	flag.DurationVar(&cfg.maxRetryWait, "max-retry-wait", maxRetryWait, "Longest delay before a retry, the site can still ask for longer with Retry-After")
+	flag.IntVar(&cfg.retryPool, "retry-pool", retryPoolSize, "Retries shared by all requests of the run, earning one back every 6s, 0 for no limit")
+	flag.StringVar(&cfg.preferSize, "prefer-size", "original", "Size downloaded of Fantia images available in several sizes: original, large or any (all of them)")
+	flag.IntVar(&cfg.chunks, "chunks", 1, "Download files of 64 MiB and more over this many connections at once, when the server supports ranges")
+	flag.Var(&cfg.ignorePatterns, "ignore-pattern", "Do not download the files whose name matches this glob, e.g. 'PREVIEW_*', can be repeated")
+	flag.Var(&cfg.includePatterns, "include-pattern", "Only download the files whose name matches this glob, e.g. '*.zip', can be repeated")
+	flag.StringVar(&cfg.fsync, "fsync", fsyncPerPost, "Flush the downloaded files to the disk: per-post (default) after every post, per-file after every file (safest, slowest), never (fastest, a crash can leave damaged files that look complete)")
+	flag.IntVar(&cfg.keepMetadataHistory, "keep-metadata-history", 0, "Keep this many previous versions of every changed metadata file, with a timestamp suffix")
+	flag.DurationVar(&cfg.partialMaxAge, "partial-max-age", 7*24*time.Hour, "Remove the unfinished downloads not resumed for this long, 0 to keep them")
+	flag.StringVar(&cfg.headerProfile, "header-profile", "minimal", "Headers sent to the site: browser, minimal or custom (only the --header values)")
+	flag.Var(&cfg.headers, "header", "Additional header sent with every request, e.g. 'Cookie: session=...', can be repeated")
+	flag.StringVar(&cfg.chaos, "chaos", "", "For testing: inject random failures into the responses, e.g. p=0.05,seed=42")
+	flag.Parse()
+
+	// Masks the secrets in the log, keeping the log for the debug bundle
+	logRedactor := newRedactor(os.Stderr, cfg.redactParams, cfg.headers, cfg.redactPaths)
+	if cfg.debugBundle != "" {
+		logRedactor.captured = &bytes.Buffer{}
 	}
+	log.SetOutput(logRedactor)
 
-	url := os.Args[1]
+	cfg.diff = cfg.diff || cfg.diffJSON
+	cfg.checkOnly = cfg.checkOnly || cfg.diff
 
-	// Validates the format of the provided URL to ensure it matches tyhe pattern for kemono.party URLs.
-	pattern := `https://(kemono\.party/[^/]+/user/\d+|coomer\.party/[^/]+/user/\w+)`
-	regex := regexp.MustCompile(pattern)
+	if cfg.listServices {
+		listServices()
+		return
+	}
+	if cfg.listRemoved {
+		if err := listRemoved("."); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if !regex.MatchString(url) {
-		log.Fatal("Provided url is not in a correct format")
+	// Spaces out all requests to prevent HTTP 429: Too many requests
+	if cfg.rate > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / cfg.rate)
 	}
 
-	// Cleans the URL from any query parameters
-	urlParts := strings.Split(url, "?")
-	url = urlParts[0]
+	romanizeFileNames = cfg.romanize
+	fixEncoding = cfg.fixEncoding
+	metadataHistory = cfg.keepMetadataHistory
 
-	// Extracts the service name from the url
-	service := strings.TrimPrefix(url, "https://")
-	service = strings.Split(service, "/")[0]
-	service = strings.TrimSuffix(service, ".party")
+	// Shares the retries between all requests of the run
+	*retryConfig = retryPolicy{maxRetries: cfg.maxRetries, backoff: cfg.retryBackoff, multiplier: cfg.retryMultiplier, maxWait: cfg.maxRetryWait}
+	if err := retryConfig.check(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.retryPool < 0 {
+		log.Fatal("--retry-pool cannot be negative")
+	}
+	retries.resize(cfg.retryPool)
 
-	// Gets the creator's name
-	name, err := getName(url)
-	if err != nil {
-		log.Fatalf("Failed to fetch user: %s", err)
+	// Keeps error pages out of the log, saving them whole only with --debug
+	errorBodyLimit = cfg.errorBodyLimit
+	if cfg.debug {
+		debugDir = debugDirName
+	}
+
+	if err := checkOrder(cfg.order); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkPreferSize(cfg.preferSize); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkFsync(cfg.fsync); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.chunks < 1 || cfg.chunks > maxConnsPerHost {
+		log.Fatalf("--chunks must be between 1 and %d", maxConnsPerHost)
+	}
+
+	if err := setHeaderProfile(cfg.headerProfile, cfg.headers); err != nil {
+		log.Fatal(err)
+	}
+
+	// Injects failures to reproduce problems with the site
+	if cfg.chaos != "" {
+		var err error
+		if faults, err = parseChaos(cfg.chaos); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Encrypts the downloaded files, the commands use the key to read them
+	if cfg.encryptKey != "" {
+		if cfg.dedupeStore != "" {
+			log.Fatal("--encrypt-key cannot be combined with --dedupe-store")
+		}
+		// The files are encrypted while they are downloaded, the other downloaders write the plaintext
+		if cfg.externalDownloader != "" || cfg.chunks > 1 {
+			log.Fatal("--encrypt-key cannot be combined with --external-downloader or --chunks")
+		}
+		var err error
+		if encryptionKey, err = loadEncryptionKey(cfg.encryptKey); err != nil {
+			log.Fatalf("Failed to load the encryption key: %s", err)
+		}
+		encryptionKeyFile = cfg.encryptKey
+	}
+
+	// Only moves data in the active hours
+	if cfg.activeHours != "" {
+		var err error
+		if schedule, err = parseActiveHours(cfg.activeHours); err != nil {
+			log.Fatal(err)
+		}
+		schedule.metadataAnytime = cfg.metadataAnytime
+	}
+
+	// Limits how long and how much the run downloads
+	if cfg.maxDuration > 0 {
+		runBudget.deadline = time.Now().Add(cfg.maxDuration)
+	}
+	runBudget.maxBytes = int64(cfg.maxBytes)
+
+	// Runs a maintenance command instead of downloading
+	if command, ok := commands[flag.Arg(0)]; ok {
+		if err := command(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Checks if URL or the creator's IDs were provided
+	byIDs := cfg.service != "" || cfg.user != "" || cfg.site != ""
+	if byIDs && flag.NArg() > 0 {
+		log.Fatal("Provide either urls or --service and --user, not both")
+	}
+	if !byIDs && flag.NArg() < 1 {
+		log.Fatal("Please provide a url")
+	}
+
+	// Falls back to the built-in downloader when the external one is not available
+	if cfg.externalDownloader != "" {
+		if err := checkExternalDownloader(cfg.externalDownloader); err != nil {
+			log.Printf("%s, using the built-in downloader", err)
+			cfg.externalDownloader = ""
+		}
+	}
+
+	// Validates the format of the provided URLs and extracts the site, service and creator from them
+	var profiles []profileConfig
+	if byIDs {
+		profile, err := profileFromIDs(cfg.site, cfg.service, cfg.user)
+		if err != nil {
+			log.Fatalf("Invalid creator: %s", err)
+		}
+		profiles = append(profiles, profile)
+	}
+	for _, arg := range flag.Args() {
+		profile, err := parseProfileURL(arg)
+		if err != nil {
+			log.Fatalf("Provided url %s is not in a correct format: %s", arg, err)
+		}
+		profiles = append(profiles, profile)
 	}
 
 	// Gets the current working directory
@@ -52,142 +364,754 @@ func main() {
 		log.Fatalf("Failed to get current working directory: %s", err)
 	}
 
+	// Refuses to start once this month's data budget is used up
+	if cfg.monthlyBudget > 0 && !cfg.watch {
+		left, err := startMonthlyBudget(wd, int64(cfg.monthlyBudget))
+		if err != nil {
+			log.Fatalf("Failed to read the transferred data: %s", err)
+		}
+		if !left {
+			os.Exit(exitTruncated)
+		}
+	}
+
+	// Caches the fetched pages in the base directory
+	if cfg.apiCache > 0 && !cfg.noCache {
+		pageCache = &responseCache{dir: filepath.Join(wd, cacheDirName), ttl: cfg.apiCache}
+	}
+
+	// Keeps the content of the files shared between creators once
+	if cfg.dedupeStore != "" && !cfg.checkOnly {
+		contentStore = &dedupeStore{dir: cfg.dedupeStore}
+	}
+
+	// Serves the metrics for the duration of the run
+	if cfg.metricsAddr != "" {
+		server := serveMetrics(cfg.metricsAddr)
+		defer server.Close()
+	}
+
+	// Cancels the run on interrupt, letting the in-flight download stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Downloads every creator once, or keeps checking them in watch mode
+	failed, missing, truncated := false, false, false
+	cycle := func(ctx context.Context) {
+		// Sums up the run, or every check of watch mode, however it ends
+		var run runSummary
+		if cfg.notifyDesktop {
+			defer func() {
+				notifyDesktop(run.notification(cfg.checkOnly))
+			}()
+		}
+
+		// Watch mode starts every cycle with what is left of the month, waiting out a used up month
+		if cfg.monthlyBudget > 0 && cfg.watch {
+			left, err := startMonthlyBudget(wd, int64(cfg.monthlyBudget))
+			if err != nil {
+				log.Printf("Failed to read the transferred data: %s", err)
+				failed = true
+				run.stopped = err
+				return
+			}
+			if !left {
+				run.stopped = errMonthlyBudget
+				return
+			}
+		}
+
+		for _, profile := range profiles {
+			if ctx.Err() != nil {
+				run.stopped = errors.New("interrupted")
+				return
+			}
+			stats, err := downloadCreator(ctx, profile, wd, &cfg)
+			run.add(stats, err)
+			if errors.Is(err, errMonthlyBudget) && cfg.watch {
+				log.Printf("Pausing until the next check: %s", err)
+				run.stopped = err
+				return
+			}
+			if errors.Is(err, errBudgetExhausted) {
+				// Ends the whole run, including watch mode
+				log.Printf("Stopping the run: %s", err)
+				truncated = true
+				run.stopped = err
+				stop()
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to download %s: %s", profile.URL(), err)
+				failed = true
+			}
+			if stats.missingFiles > 0 {
+				missing = true
+			}
+		}
+	}
+
+	if cfg.watch {
+		watch(ctx, cfg.interval, filepath.Join(wd, recheckFileName), cycle)
+	} else {
+		cycle(ctx)
+	}
+
+	if modified, untouched := metadataWrites.modified.Load(), metadataWrites.untouched.Load(); modified+untouched > 0 {
+		log.Printf("Metadata files: %d modified, %d unchanged", modified, untouched)
+	}
+
+	// Shows how unhealthy the site was during the run
+	retried, refused := retries.counts()
+	if refused > 0 {
+		log.Printf("Retried %d failed requests, %d more failed without a retry because the retry pool was empty", retried, refused)
+	} else if retried > 0 {
+		log.Printf("Retried %d failed requests", retried)
+	}
+
+	if cfg.debugBundle != "" {
+		summary := fmt.Sprintf("failed: %t\nmissing: %t\ntruncated: %t\npages: %s\nfiles: %s\nretries: %d\nrefused retries: %d\n", failed, missing, truncated, formatSize(transferred.pages.Load()), formatSize(transferred.files.Load()), retried, refused)
+		if err := logRedactor.writeDebugBundle(cfg.debugBundle, summary); err != nil {
+			log.Printf("Failed to write the debug bundle: %s", err)
+		} else {
+			log.Printf("Wrote the debug bundle to %s", cfg.debugBundle)
+		}
+	}
+
+	if truncated {
+		stop()
+		os.Exit(exitTruncated)
+	}
+	if cfg.watch {
+		return
+	}
+	if failed {
+		stop()
+		os.Exit(1)
+	}
+	if cfg.checkOnly && missing {
+		stop()
+		os.Exit(2)
+	}
+}
+
+// Downloads all posts of a creator
+func downloadCreator(ctx context.Context, profile profileConfig, wd string, cfg *config) (summary, error) {
+	var stats summary
+	url := profile.URL()
+
+	// Accounts the data transferred for the creator in the base directory once it is done, only
+	// --monthly-budget needs the usage file and read-only runs leave the archive untouched
+	pages, files := transferred.pages.Load(), transferred.files.Load()
+	defer func() {
+		transfer := usageCounters{Pages: transferred.pages.Load() - pages, Files: transferred.files.Load() - files}
+		if cfg.monthlyBudget == 0 || cfg.checkOnly || transfer.total() == 0 {
+			return
+		}
+		if err := recordUsage(wd, profile.Site+"/"+profile.Service+"/"+profile.User, transfer); err != nil {
+			log.Printf("Failed to record the transferred data: %s", err)
+		}
+	}()
+
+	// Gets the creator's name
+	name, err := getName(ctx, url)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
 	// Creates a directory for the downloaded media
-	dir := fmt.Sprintf("%s/%s/%s", wd, service, name)
-	err = os.MkdirAll(dir, 0755)
+	dir, prefix := creatorDirectory(wd, profile, name)
+
+	indexed := indexedArchive(dir, profile.Service, cfg)
+	log.Printf("Naming the files of %s %s", name, namingScheme(indexed))
+	if !cfg.checkOnly {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return stats, fmt.Errorf("failed to create download directory: %w", err)
+		}
+
+		// Remembers where the creator was downloaded from
+		if err := saveProfile(dir, profile, name, indexed); err != nil {
+			log.Printf("Failed to save the profile: %s", err)
+		}
+	}
+
+	// Gets the total number of posts to report the progress
+	total, err := numberOfPosts(ctx, url)
 	if err != nil {
-		log.Fatalf("Failed to create downlaod directory: %s", err)
+		return stats, fmt.Errorf("failed to fetch all posts: %w", err)
+	}
+
+	c := &creator{name: name, prefix: prefix, site: profile.Site, service: profile.Service, baseURL: profile.BaseURL, directory: dir, cfg: cfg, stats: &stats, indexed: indexed}
+
+	// Lists the existing files once instead of checking each of them on disk
+	if !cfg.noSnapshot {
+		c.snapshot, err = takeSnapshot(dir)
+		if err != nil {
+			return stats, fmt.Errorf("failed to list download directory: %w", err)
+		}
+	}
+
+	// Compares the posts on the site with the files downloaded by the previous runs
+	if cfg.diff {
+		c.changes, err = newChangeset(profile, name, dir, prefix)
+		if err != nil {
+			return stats, fmt.Errorf("failed to list download directory: %w", err)
+		}
 	}
 
-	// Retrieves teh list of all posts from the creator's page
-	posts, err := getAllPosts(url)
+	// Adds the name patterns saved in the creator's profile to the options
+	saved, _ := readProfile(dir)
+	c.filter = nameFilter{
+		ignore:  append(append([]string{}, cfg.ignorePatterns...), saved.IgnorePatterns...),
+		include: append(append([]string{}, cfg.includePatterns...), saved.IncludePatterns...),
+	}
+	for _, pattern := range append(saved.IgnorePatterns, saved.IncludePatterns...) {
+		if err := checkPattern(pattern); err != nil {
+			return stats, fmt.Errorf("%s: %w", profileName, err)
+		}
+	}
+
+	// Drops the unfinished downloads no run resumed
+	if !cfg.checkOnly {
+		cleanPartials(dir, cfg.partialMaxAge)
+	}
+
+	// Skips the files taken down from the site without asking for them again
+	statuses, removed, err := manifestStatuses(dir)
 	if err != nil {
-		log.Fatalf("Failed to fetch all posts: %s", err)
+		return stats, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	c.removed = removed
+
+	// Opens the manifest recording every file action, the files are only recorded again
+	// when their status changed
+	if !cfg.noManifest && !cfg.checkOnly {
+		c.manifest, err = openManifest(dir)
+		if err != nil {
+			return stats, fmt.Errorf("failed to open manifest: %w", err)
+		}
+		c.manifest.statuses = statuses
+		defer c.manifest.Close()
 	}
 
-	// Downloads every post's content
-	for _, post := range posts {
-		postUrl := fmt.Sprintf("https://%s.party%s", service, post)
-		err := downloadPost(postUrl, dir, name, service)
+	// Stops the pipeline early when the run budget is used up
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Streams the posts from the creator's pages through the metadata stage into the downloads
+	links, errc := streamPosts(ctx, url, total)
+	posts := fetchPosts(ctx, links, profile.BaseURL, cfg.postWorkers)
+
+	// Remembers which posts still exist on the site
+	seen := map[string]bool{}
+	var notFound []string
+
+	// Other orders than the listing's need all posts before the first download
+	ordered := cfg.order != orderNewestFirst && !cfg.checkOnly
+	var queued []post
+
+	var exhausted error
+	done := 0
+	for post := range posts {
+		done++
+		if errors.Is(post.err, ErrNotFound) {
+			notFound = append(notFound, post.id)
+		} else if post.id != "" {
+			seen[post.id] = true
+		}
+		if ordered {
+			log.Printf("Fetched post %d/%d (%d%%): %s", done, total, done*100/total, post.url)
+			queued = append(queued, post)
+			continue
+		}
+		log.Printf("Downloading post %d/%d (%d%%): %s", done, total, done*100/total, post.url)
+		runMetrics.queueDepth(total - done)
+
+		err := downloadPost(ctx, post, c)
+		if errors.Is(err, errBudgetExhausted) {
+			exhausted = err
+			cancel()
+			break
+		}
 		if err != nil {
 			log.Printf("Failed to download post: %s", err)
+			stats.failures++
+			runMetrics.failure(err)
 		}
-		// Adds a delay between each request to prevent HTTP 429: Too many requests
-		time.Sleep(300 * time.Millisecond)
 	}
+
+	err = <-errc
+	if ordered && ctx.Err() == nil {
+		exhausted = downloadOrdered(ctx, queued, c)
+	}
+	if exhausted != nil {
+		log.Printf("Download stopped: %s", exhausted)
+	} else if errors.Is(err, context.Canceled) {
+		log.Println("Download interrupted")
+	} else if err != nil {
+		log.Printf("Failed to fetch all posts: %s", err)
+	}
+
+	// Reconciles the local files with the posts found on the site
+	if (cfg.pruneReport || cfg.pruneDelete) && !cfg.checkOnly {
+		if err := prune(c, seen, notFound, err == nil && ctx.Err() == nil); err != nil {
+			log.Printf("Failed to prune removed posts: %s", err)
+		}
+	}
+
+	if cfg.checkOnly {
+		c.changes.finish(seen, notFound, err == nil && ctx.Err() == nil, stats.missingBytes)
+		if err := c.changes.print(cfg.diffJSON); err != nil {
+			log.Printf("Failed to print the changes: %s", err)
+		}
+		log.Printf("Checked %s: %d posts with %d files (%s) missing locally, %d failures", name, stats.missingPosts, stats.missingFiles, formatSize(stats.missingBytes), stats.failures)
+		return stats, nil
+	}
+
+	if cfg.htmlIndex {
+		if err := generateGallery(dir, prefix, name); err != nil {
+			log.Printf("Failed to generate the gallery: %s", err)
+		}
+	}
+	if cfg.playlist {
+		if _, err := generatePlaylist(dir, prefix); err != nil {
+			log.Printf("Failed to generate the playlist: %s", err)
+		}
+	}
+	if cfg.sums {
+		if _, _, err := updateSums(dir, prefix); err != nil {
+			log.Printf("Failed to update %s: %s", tableOfContentsName, err)
+		}
+	}
+
+	log.Printf("Finished downloading %s: %d new files, %d failures", name, stats.files, stats.failures)
+	if stats.ignored > 0 {
+		log.Printf("%d files were left out by the name patterns", stats.ignored)
+	}
+	if stats.removedPosts > 0 {
+		log.Printf("%d posts were removed from the site before their page was fetched, recorded in the manifest", stats.removedPosts)
+	}
+	if stats.removed > 0 {
+		log.Printf("%d files were removed from the site, see --list-removed", stats.removed)
+	}
+	if stats.stubs > 0 {
+		log.Printf("%d files are likely preview stubs, see the manifest entries with the stub status", stats.stubs)
+	}
+	if stats.hookFailures > 0 {
+		log.Printf("%d hook commands failed", stats.hookFailures)
+	}
+	return stats, exhausted
 }
 
-// Downloads media content from a post
-func downloadPost(url string, directory string, name string, service string) error {
-	log.Printf("Downloading post: %s", url)
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+// Reports whether the files of the creator's directory are named with their position in the post,
+// which new archives do by default unless their service keeps plain names
+func indexedArchive(dir string, service string, cfg *config) bool {
+	saved, _ := readProfile(dir)
+	_, err := os.Stat(dir)
+	if cfg.indexFiles || saved.Indexed {
+		return true
 	}
-	defer res.Body.Close()
+	return os.IsNotExist(err) && !cfg.noIndexFiles && !services[service].plainNames
+}
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
-	if err != nil {
-		return err
+// Returns the path the file of the post is saved to, used holds the paths taken by the other files of the post
+func (c *creator) destination(file string, postID string, index int, count int, used map[string]bool) string {
+	// Falls back to the hashed name when another file of the post has the same original name
+	dest := filePath(file, c.directory, c.prefix, postID)
+	if used[dest] {
+		dest = hashedFilePath(file, c.directory, c.prefix, postID)
 	}
+	used[dest] = true
+
+	// Files downloaded before the original names were used keep their old name,
+	// unless another file of the post already took it
+	stored := func(path string) bool {
+		return c.snapshot.exists(path) || c.snapshot.exists(path+encryptedSuffix)
+	}
+	if !stored(dest) {
+		exists := func(path string) bool {
+			return !used[path] && stored(path)
+		}
+		if legacy, ok := legacyFilePath(file, c.directory, c.prefix, postID, exists); ok {
+			dest = legacy
+			used[dest] = true
+		}
+	}
+
+	// Files are named with their position in the post unless they were downloaded without it
+	if indexed := indexedFilePath(file, c.directory, c.prefix, postID, index, count); stored(indexed) || c.indexed && !stored(dest) {
+		return indexed
+	}
+
+	return dest
+}
 
-	var files []string
-	// Extracts the media URLs from the Downloads section of the post
-	doc.Find("h2:contains('Downloads')").Next().Find("a.post__attachment-link").Each(func(i int, selection *goquery.Selection) {
-		file, exists := selection.Attr("href")
-		if exists {
-			files = append(files, file)
+// A file of a post with the path it is saved to
+type postFile struct {
+	url      string
+	dest     string
+	complete bool
+}
+
+// Returns the files of a post with their destinations
+func (c *creator) postFiles(post post) []postFile {
+	var files []postFile
+	used := map[string]bool{}
+	skipped := c.skippedVariants(post)
+	for i, file := range post.files {
+		// Only one size of the same Fantia image is downloaded
+		if skipped[i] {
+			continue
+		}
+
+		index := i + 1
+		if i < len(post.indexes) {
+			index = post.indexes[i]
+		}
+
+		// Coomer links are relative to the site, the query carries the original file name
+		file, err := resolveFileURL(c.baseURL, file)
+		if err != nil {
+			log.Printf("Failed to parse file url: %s", err)
+			c.stats.failures++
+			continue
+		}
+
+		dest := c.destination(file, post.id, index, len(post.files), used)
+
+		// Leaves out the files matching the name patterns
+		if c.filter.skips(fileName(file)) {
+			c.stats.ignored++
+			c.manifest.record(post, file, dest, statusIgnored, nil)
+			continue
 		}
-	})
+		complete := c.snapshot.complete(dest)
 
-	// Extracts the media URLs from the Files section of the post
-	doc.Find("h2:contains('Files')").Next().Find("a.fileThumb").Each(func(i int, selection *goquery.Selection) {
-		file, exists := selection.Attr("href")
-		if exists {
-			files = append(files, file)
+		// Files named without an extension were saved with the extension of their content
+		if !complete && filepath.Ext(dest) == "" {
+			for _, ext := range extensionCandidates(file) {
+				if c.snapshot.exists(dest+ext) || c.snapshot.exists(dest+ext+encryptedSuffix) {
+					dest += ext
+					complete = c.snapshot.complete(dest)
+					break
+				}
+			}
 		}
-	})
 
-	// Matches the creator's id from the url using regex
-	regex := regexp.MustCompile(`.*\/\w+\/post\/(\d+)`)
-	match := regex.FindStringSubmatch(url)
+		// Files stored encrypted are not downloaded again
+		if !complete && c.snapshot.complete(dest+encryptedSuffix) {
+			dest += encryptedSuffix
+			complete = true
+		}
+
+		files = append(files, postFile{url: file, dest: dest, complete: complete})
+	}
+
+	return files
+}
+
+// Downloads media content from a post
+func downloadPost(ctx context.Context, post post, c *creator) error {
+	if errors.Is(post.err, ErrNotFound) {
+		c.postRemoved(post)
+		return nil
+	}
+	if post.err != nil {
+		return post.err
+	}
+
+	// Only looks for the missing files in check-only mode
+	if c.cfg.checkOnly {
+		var missing []string
+		for _, f := range c.postFiles(post) {
+			if _, removed := c.removed[f.url]; !f.complete && (!removed || c.cfg.recheckRemoved) {
+				checkFile(withFileTransfer(ctx, post.url), f.url, c.stats)
+				missing = append(missing, filepath.Base(f.dest))
+			}
+		}
+		if len(missing) > 0 {
+			c.stats.missingPosts++
+		}
+		c.changes.add(post, missing)
+		return nil
+	}
 
 	// Download all media from the post
-	for _, file := range files {
-		if service == "coomer" {
-			file = strings.Split(file, "?")[0]
-			file = fmt.Sprintf("https://coomer.party%s", file)
+	for _, f := range c.postFiles(post) {
+		if err := downloadPostFile(ctx, post, f, c); err != nil {
+			return err
+		}
+	}
+
+	return finishPost(post, c)
+}
+
+// Records a listed post whose page was not found, so the archive remembers it existed
+func (c *creator) postRemoved(post post) {
+	log.Printf("Post was removed from the site before its page was fetched: %s", post.url)
+	c.stats.removedPosts++
+	c.manifest.recordRemovedPost(post)
+}
+
+// Downloads a single file of a post, only fails when the run must not start new files
+func downloadPostFile(ctx context.Context, post post, f postFile, c *creator) error {
+	file, dest := f.url, f.dest
+
+	// Downloads stubs again once the full file was imported
+	if f.complete && !isEncrypted(dest) && stubReplaced(ctx, c, post.url, file, dest) {
+		log.Printf("A larger version of the stub %s was imported", filepath.Base(dest))
+		if err := os.Remove(dest); err != nil {
+			log.Printf("Failed to remove the stub: %s", err)
+		} else {
+			f.complete = false
 		}
+	}
+	if f.complete {
+		c.manifest.record(post, file, dest, statusSkipped, nil)
+		return nil
+	}
+
+	// Files taken down from the site are not coming back, unless asked to look with --recheck-removed
+	if entry, ok := c.removed[file]; ok && !c.cfg.recheckRemoved {
+		c.stats.removed++
+		c.manifest.record(post, file, dest, statusRemoved, errors.New(entry.Error))
+		return nil
+	}
 
-		err := downloadFile(file, directory, name, match[1])
+	// Leaves out the likely preview stubs with --skip-stubs
+	if c.cfg.skipStubs && likelyStub(c.site, dest, fileSize(withFileTransfer(ctx, post.url), file)) {
+		c.stats.stubs++
+		c.manifest.record(post, file, dest, statusStub, nil)
+		return nil
+	}
+
+	// Finishes the post early once the run must not start new files
+	if err := runBudget.check(); err != nil {
+		return err
+	}
+
+	downloaded, err := downloadFile(withFileTransfer(ctx, post.url), file, dest, c.cfg)
+	if errors.Is(err, ErrRemoved) {
+		log.Printf("File was removed from the site: %s", file)
+		c.stats.removed++
+		c.manifest.record(post, file, dest, statusRemoved, err)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to download file: %s", err)
+		c.stats.failures++
+		runMetrics.failure(err)
+		c.manifest.record(post, file, dest, statusFailed, err)
+		return nil
+	}
+	if !downloaded {
+		c.manifest.record(post, file, dest, statusSkipped, nil)
+		return nil
+	}
+
+	// Names without an extension get the extension of their content, encrypted files were
+	// downloaded into the encrypted name
+	if encryptionKey != nil {
+		dest += encryptedSuffix
+	} else if named, err := addExtension(file, dest); err != nil {
+		log.Printf("Failed to add the extension to %s: %s", filepath.Base(dest), err)
+	} else {
+		dest = named
+	}
+
+	rememberRunFile(file, dest)
+
+	// Stubs are kept but recorded, a later run checks for the full file
+	status := statusDownloaded
+	if info, err := os.Stat(dest); err == nil && likelyStub(c.site, dest, info.Size()) {
+		c.stats.stubs++
+		status = statusStub
+	}
+
+	c.snapshot.add(dest)
+
+	// Runs the post-download hook on the new file
+	if c.cfg.execAfterFile != "" {
+		err := runHook(c.cfg.execAfterFile, "{}", dest, c.cfg.execTimeout)
 		if err != nil {
-			log.Printf("Failed to download file: %s", err)
+			log.Printf("Hook failed for file %s: %s", file, err)
+			c.stats.hookFailures++
+			if c.cfg.execStrict {
+				c.stats.failures++
+				c.manifest.record(post, file, dest, statusFailed, err)
+				c.syncFile(dest)
+				return nil
+			}
+		}
+	}
+	c.stats.files++
+	sum := c.manifest.record(post, file, dest, status, nil)
+
+	// Flushes the file after recording it, so --fsync per-file flushes its manifest line with it
+	c.syncFile(dest)
+
+	// Records where the file came from on the file itself
+	if c.cfg.xattr {
+		if err := writeProvenance(dest, file, post.url, sum); err != nil {
+			log.Printf("Failed to write extended attributes of %s: %s", filepath.Base(dest), err)
 		}
 	}
 
 	return nil
 }
 
-// Downloads a file from a URL
-func downloadFile(url string, directory string, name string, postID string) error {
-	// Constructs the file path for the resulting file
-	file := fmt.Sprintf("%s/%s_%s_%s", directory, name, postID, path.Base(url))
+// Runs the post hook once all files of the post were handled
+func finishPost(post post, c *creator) error {
+	c.syncPost()
 
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		client := grab.NewClient()
-		req, err := grab.NewRequest(file, url)
+	if c.cfg.execAfterPost != "" {
+		err := runHook(c.cfg.execAfterPost, "{dir}", c.directory, c.cfg.execTimeout)
 		if err != nil {
-			return err
+			log.Printf("Hook failed for post %s: %s", post.url, err)
+			c.stats.hookFailures++
+			if c.cfg.execStrict {
+				return err
+			}
 		}
-
-		client.Do(req)
 	}
 
 	return nil
 }
 
-// Returns array of links to all posts from teh creator
-func getAllPosts(url string) ([]string, error) {
-	pages, err := numberOfPages(url)
-	if err != nil {
-		return nil, err
+// Downloads a file from a URL into the file path, reports whether the file was newly downloaded
+func downloadFile(ctx context.Context, url string, file string, cfg *config) (bool, error) {
+	// A file left behind with an aria2c control file is an unfinished download
+	if _, err := os.Stat(file); !os.IsNotExist(err) && !externalIncomplete(file) {
+		return false, nil
+	}
+
+	// Encrypts the content on the way with --encrypt-key, the dedupe store and the other
+	// downloaders would keep the plaintext on disk
+	encrypted := encryptionKey != nil
+
+	// Links the content from the dedupe store when another creator already has it
+	if !encrypted && contentStore.link(url, file) {
+		return true, nil
+	}
+
+	// Links the content downloaded for another creator earlier in the run
+	if !encrypted && linkRunFile(url, file) {
+		return true, nil
 	}
 
-	// Iterates through every page and extracts all posts
-	var posts []string
-	for i := 0; i < pages; i++ {
-		page := fmt.Sprintf("%s?o=%d", url, i*50)
-		log.Println(page)
-		res, err := http.Get(page)
+	runMetrics.activeDownloads(1)
+	defer runMetrics.activeDownloads(-1)
+
+	if encrypted {
+		size, err := downloadEncrypted(ctx, url, file)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		defer res.Body.Close()
+		fileTransferred(size)
+		return true, nil
+	}
 
-		doc, err := goquery.NewDocumentFromReader(res.Body)
-		if err != nil {
-			return nil, err
+	// Delegates large files to the external downloader
+	if cfg.externalDownloader != "" && largeFile(ctx, url, cfg.externalMinSize) {
+		if err := externalDownload(ctx, cfg, url, file); err != nil {
+			return false, err
 		}
+		if info, err := os.Stat(file); err == nil {
+			fileTransferred(info.Size())
+		}
+		storeFile(file)
+		return true, nil
+	}
 
-		// Searches for the post links in the HTML
-		doc.Find("article.post-card").Each(func(i int, selection *goquery.Selection) {
-			postUrl, _ := selection.Find("a").Attr("href")
-			posts = append(posts, postUrl)
-		})
+	// Splits large files into ranges downloaded over several connections, when the server serves ranges
+	if cfg.chunks > 1 {
+		if size := rangeSize(ctx, url); size >= chunkedMinSize {
+			if err := chunkedDownload(ctx, url, file, size, cfg.chunks); err != nil {
+				return false, err
+			}
+			fileTransferred(size)
+			storeFile(file)
+			return true, nil
+		}
 	}
 
-	return posts, nil
+	// Downloads into the unfinished downloads of the creator, resumed even when the file is named differently
+	n, err := singleDownload(ctx, url, file)
+	if err != nil {
+		return false, err
+	}
+
+	fileTransferred(n)
+	storeFile(file)
+	return true, nil
+}
+
+// Moves a downloaded file into the dedupe store, the file stays in place when that fails
+func storeFile(file string) {
+	if err := contentStore.add(file); err != nil {
+		log.Printf("Failed to add %s to the dedupe store: %s", file, err)
+	}
+}
+
+// Constructs the file path for the file downloaded from a URL
+func filePath(url string, directory string, name string, postID string) string {
+	return fmt.Sprintf("%s/%s_%s_%s", directory, name, postID, fileName(url))
+}
+
+// Constructs the file path for the file downloaded from a URL prefixed with its position in the post,
+// zero-padded to the width of the number of files
+func indexedFilePath(url string, directory string, name string, postID string, index int, count int) string {
+	width := len(strconv.Itoa(count))
+	if width < 2 {
+		width = 2
+	}
+	return fmt.Sprintf("%s/%s_%s_%0*d_%s", directory, name, postID, width, index, fileName(url))
 }
 
-// Returns the total number of pages for a creator
-func numberOfPages(url string) (int, error) {
-	res, err := http.Get(url)
+// Constructs the file path for the file downloaded from a URL using its hashed server name
+func hashedFilePath(url string, directory string, name string, postID string) string {
+	return fmt.Sprintf("%s/%s_%s_%s", directory, name, postID, hashedFileName(url))
+}
+
+// Fetches a page and parses its HTML
+func fetchDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	// The Accept header comes from the header profile
+	body, err := openPage(ctx, url, http.Header{})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer body.Close()
+
+	// Keeps the beginning of the body to describe a page that could not be parsed
+	var head bytes.Buffer
+	doc, err := goquery.NewDocumentFromReader(io.TeeReader(body, &limitedWriter{w: &head, n: bodyReadLimit()}))
+	if err != nil {
+		resErr := &ResponseError{Kind: ErrDecode, URL: url, Err: err}
+		describeBody(resErr, head.Bytes())
+		return nil, resErr
+	}
+
+	return doc, nil
+}
+
+// Opens the body of a page, going through the page cache when it is enabled
+func openPage(ctx context.Context, url string, header http.Header) (io.ReadCloser, error) {
+	if pageCache != nil {
+		return pageCache.fetch(ctx, url, header)
 	}
-	defer res.Body.Close()
 
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+	res, err := doRequest(ctx, http.MethodGet, url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Returns the total number of posts of a creator
+func numberOfPosts(ctx context.Context, url string) (int, error) {
+	doc, err := fetchDocument(ctx, url)
 	if err != nil {
 		return 0, err
 	}
@@ -195,39 +1119,41 @@ func numberOfPages(url string) (int, error) {
 	// Searches for the HTML part containing the total number of posts
 	postText := doc.Find("div.paginator small").Text()
 
-	// Matches the number of posts from the element
-	pattern := `Showing \d+ - \d+ of (\d+)`
+	// Creators with a single page have no paginator, the page itself holds all of their posts
+	if strings.TrimSpace(postText) == "" {
+		return doc.Find("article.post-card").Length(), nil
+	}
+
+	// Matches the number of posts from the element, including the "Showing 1 of 1" variant
+	pattern := `Showing\s+\d+\s*(?:-\s*\d+\s*)?of\s+([\d,]+)`
 	regex := regexp.MustCompile(pattern)
 	matches := regex.FindStringSubmatch(postText)
 	if len(matches) < 2 {
 		return 0, errors.New("could not extract the number of posts")
 	}
 
-	posts, err := strconv.Atoi(matches[1])
+	posts, err := strconv.Atoi(strings.ReplaceAll(matches[1], ",", ""))
 	if err != nil {
 		return 0, errors.New("could not extract the number of posts")
 	}
 
+	return posts, nil
+}
+
+// Returns the total number of pages for the given number of posts
+func numberOfPages(posts int) int {
 	// Adds 49 to the total number of posts to account for rounding up when calculating the number of pages.
 	// Then divides teh adjusted total by 50 to calculate the total number of pages
-	pageCount := (posts + 49) / 50
-
-	return pageCount, nil
+	return (posts + 49) / 50
 }
 
 // Returns name of the creator
-func getName(url string) (string, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer res.Body.Close()
-
-	doc, err := goquery.NewDocumentFromReader(res.Body)
+func getName(ctx context.Context, url string) (string, error) {
+	doc, err := fetchDocument(ctx, url)
 	if err != nil {
 		return "", err
 	}
 
-	name := doc.Find("span[itemprop='name']").Text()
+	name := validText(doc.Find("span[itemprop='name']").Text())
 	return name, nil
 }
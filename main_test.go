@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFile(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("download", "video.mp4")
+	path := link[:strings.Index(link, "?")]
+	dest := filepath.Join(t.TempDir(), "video.mp4")
+	cfg := &config{}
+
+	downloaded, err := downloadFile(context.Background(), site.URL+link, dest, cfg)
+	if err != nil || !downloaded {
+		t.Fatalf("downloadFile = %t, %v", downloaded, err)
+	}
+	if content, _ := os.ReadFile(dest); !bytes.Equal(content, mockContents[path]) {
+		t.Fatal("downloaded content differs")
+	}
+
+	// Existing files are not requested again
+	before := site.count(path)
+	downloaded, err = downloadFile(context.Background(), site.URL+link, dest, cfg)
+	if err != nil || downloaded {
+		t.Fatalf("downloadFile of an existing file = %t, %v", downloaded, err)
+	}
+	if site.count(path) != before {
+		t.Error("an existing file was requested again")
+	}
+}
+
+func TestDownloadFileNotFound(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	dest := filepath.Join(t.TempDir(), "missing.jpg")
+
+	_, err := downloadFile(context.Background(), site.URL+"/data/00/00/missing.jpg", dest, &config{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("a failed download left the file in place")
+	}
+}
+
+func TestDownloadFileResumes(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("resume", "archive.zip")
+	path := link[:strings.Index(link, "?")]
+	site.fail(path, mockResponse{status: 200, body: string(mockContents[path]), truncate: true})
+	dest := filepath.Join(t.TempDir(), "archive.zip")
+
+	if _, err := downloadFile(context.Background(), site.URL+link, dest, &config{}); err == nil {
+		t.Fatal("a truncated download succeeded")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatal("a truncated download was moved into place")
+	}
+
+	downloaded, err := downloadFile(context.Background(), site.URL+link, dest, &config{})
+	if err != nil || !downloaded {
+		t.Fatalf("resumed downloadFile = %t, %v", downloaded, err)
+	}
+	if content, _ := os.ReadFile(dest); !bytes.Equal(content, mockContents[path]) {
+		t.Fatal("resumed content differs")
+	}
+}
+
+func TestDownloadCreator(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 3)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst, noManifest: true}
+
+	stats, err := downloadCreator(context.Background(), site.profile(), wd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 9 || stats.failures != 0 {
+		t.Fatalf("downloaded %d files with %d failures, want 9 and 0", stats.files, stats.failures)
+	}
+
+	dir := filepath.Join(wd, "kemono", "patreon", "Bob [1]")
+	for _, name := range []string{"Bob_1000_00_image.jpg", "Bob_1000_01_archive.zip", "Bob_1000_02_image.jpg", "profile.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("missing %s: %s", name, err)
+		}
+	}
+
+	// A second run finds everything in place
+	stats, err = downloadCreator(context.Background(), site.profile(), wd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 0 {
+		t.Errorf("second run downloaded %d files", stats.files)
+	}
+}
+
+func TestDownloadCreatorManifestUnchanged(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 2)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst}
+
+	if _, err := downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(wd, "kemono", "patreon", "Bob [1]", manifestName)
+	before, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(before), statusDownloaded); n != 6 {
+		t.Fatalf("manifest records %d downloads, want 6", n)
+	}
+
+	// Later runs skipping the existing files leave the manifest as it is
+	for i := 0; i < 2; i++ {
+		if _, err := downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after, _ := os.ReadFile(file); !bytes.Equal(after, before) {
+		t.Errorf("manifest grew from %d to %d bytes over runs without changes", len(before), len(after))
+	}
+}
+
+func TestNumberOfPosts(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    int
+	}{
+		{"listing_empty.html", 0},
+		{"listing_single.html", 3},
+		{"listing_multi.html", 1234},
+		{"listing_one_of_one.html", 1},
+	}
+	testRun(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	for _, test := range tests {
+		got, err := numberOfPosts(context.Background(), srv.URL+"/"+test.fixture)
+		if err != nil || got != test.want {
+			t.Errorf("numberOfPosts(%s) = %d, %v, want %d", test.fixture, got, err, test.want)
+		}
+	}
+}
+
+func TestNumberOfPages(t *testing.T) {
+	for posts, want := range map[int]int{0: 0, 1: 1, 49: 1, 50: 1, 51: 2, 100: 2, 1234: 25} {
+		if got := numberOfPages(posts); got != want {
+			t.Errorf("numberOfPages(%d) = %d, want %d", posts, got, want)
+		}
+	}
+}
+
+func TestDownloadCreatorRecordsUsage(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+
+	// Runs without --monthly-budget and read-only runs record nothing
+	for _, cfg := range []*config{
+		{order: orderNewestFirst, noManifest: true},
+		{order: orderNewestFirst, noManifest: true, checkOnly: true, monthlyBudget: 1 << 30},
+	} {
+		wd := t.TempDir()
+		if _, err := downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(wd, usageFileName)); !os.IsNotExist(err) {
+			t.Errorf("run with %+v wrote %s", *cfg, usageFileName)
+		}
+	}
+
+	// Forgets the files downloaded above, which would be linked instead of transferred
+	runFiles.Lock()
+	runFiles.paths = map[string]string{}
+	runFiles.Unlock()
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst, noManifest: true, monthlyBudget: 1 << 30}
+	if _, err := downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+		t.Fatal(err)
+	}
+	u, err := loadUsage(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := u.Creators["kemono/patreon/1"]; c == nil || c.Files == 0 || c.Pages == 0 {
+		t.Errorf("usage of the creator = %+v, want pages and files", c)
+	}
+}
+
+func TestDownloadPostFileFsyncPerFile(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	dir := t.TempDir()
+	m, err := openManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	c := &creator{site: "kemono", directory: dir, cfg: &config{fsync: fsyncPerFile}, stats: &summary{}, manifest: m}
+
+	link := mockFile("fsync", "image.jpg")
+	p := post{url: site.URL + "/patreon/user/1/post/1", id: "1"}
+	if err := downloadPostFile(context.Background(), p, postFile{url: site.URL + link, dest: filepath.Join(dir, "image.jpg")}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest line of the file is on the disk as soon as the file is
+	content, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `"status":"`+statusDownloaded+`"`) {
+		t.Errorf("manifest %q is missing the downloaded file", content)
+	}
+}
+
+func TestGetNameCancelled(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+
+	// An interrupted run does not look the name up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := getName(ctx, site.profile().URL()); !errors.Is(err, context.Canceled) {
+		t.Errorf("getName with a cancelled context = %v, want context.Canceled", err)
+	}
+	if n := site.count("/patreon/user/1"); n != 0 {
+		t.Errorf("the creator's page was requested %d times", n)
+	}
+
+	if name, err := getName(context.Background(), site.profile().URL()); err != nil || name != "Bob" {
+		t.Errorf("getName = %q, %v, want Bob", name, err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Name of the manifest file in the creator's directory
+const manifestName = "manifest.jsonl"
+
+// Statuses of the file actions recorded in the manifest
+const (
+	statusDownloaded = "downloaded"
+	statusSkipped    = "skipped"
+	statusFailed     = "failed"
+	statusAdopted    = "adopted"
+	statusStub       = "stub"
+	statusRemoved    = "removed"
+	statusIgnored    = "ignored"
+	// The post was in the listing but its page was gone, the entry has no file
+	statusPostRemoved = "post_removed"
+)
+
+// A single file action recorded in the manifest
+type manifestEntry struct {
+	Time     time.Time `json:"time"`
+	PostID   string    `json:"post_id"`
+	Filename string    `json:"filename"`
+	Original string    `json:"original_name,omitempty"`
+	// Extension added to a file named without one
+	Extension string `json:"added_extension,omitempty"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	URL       string `json:"url"`
+	Host      string `json:"host,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+	PostURL   string `json:"post_url,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Date      string `json:"published,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Append-only JSON Lines record of every file action in a creator's directory.
+// A nil manifest ignores all records, which is used when the manifest is disabled.
+type manifest struct {
+	mu        sync.Mutex
+	file      *os.File
+	directory string
+	// Lines waiting for the end of the post, so a post is written at once
+	pending []byte
+	// Last status recorded for each file, by URL, when known
+	statuses map[string]string
+}
+
+// Reports whether recording the status of a file would repeat what the manifest already says,
+// such as an existing file skipped on every run. New downloads are always recorded.
+func unchangedStatus(last string, status string) bool {
+	switch {
+	case status == statusDownloaded:
+		return false
+	case status == last:
+		return true
+	case status == statusSkipped:
+		return last == statusDownloaded || last == statusAdopted || last == statusStub
+	}
+	return false
+}
+
+// Version of the manifest format written by this version, stamped on its first line.
+// Manifests without the line are from before the format was versioned.
+const manifestSchema = 1
+
+// Returns the version stamped on the first line of the manifest, 0 for manifests without it
+func manifestVersion(file string) (int, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return metadataSchema(line), nil
+}
+
+// Returns the first line of a new manifest, stamping the version of its format
+func manifestHeader() []byte {
+	return []byte(fmt.Sprintf("{\"_schema\":%d}\n", manifestSchema))
+}
+
+// Opens the manifest in the directory for appending
+func openManifest(directory string) (*manifest, error) {
+	path := filepath.Join(directory, manifestName)
+
+	// Leaves a manifest of a newer version alone, its entries may differ from the ones written here
+	version, err := manifestVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	if version > manifestSchema {
+		return nil, fmt.Errorf("%s was written by a newer version of kemono-dl, not appending to it", path)
+	}
+
+	// Drops the entry cut off when a previous run crashed mid-write
+	if _, err := repairJSONLines(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		if _, err := file.Write(manifestHeader()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &manifest{file: file, directory: directory}, nil
+}
+
+// Appends an entry for the file of the post downloaded from the URL to the manifest,
+// returns the hash of the file when it was computed
+func (m *manifest) record(post post, rawURL string, file string, status string, cause error) string {
+	if m == nil {
+		return ""
+	}
+	m.mu.Lock()
+	last, known := m.statuses[rawURL]
+	m.mu.Unlock()
+	if known && unchangedStatus(last, status) {
+		return ""
+	}
+
+	entry := manifestEntry{
+		Time:     time.Now().UTC(),
+		PostID:   post.id,
+		Filename: filepath.Base(file),
+		Path:     file,
+		URL:      rawURL,
+		PostURL:  post.url,
+		Status:   status,
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		entry.Host = u.Host
+	}
+	entry.Variant, _ = fantiaVariant(post.url, rawURL)
+	if romanizeFileNames {
+		if original := originalFileName(rawURL); original != fileName(rawURL) {
+			entry.Original = original
+		}
+	}
+	if ext := filepath.Ext(plainName(file)); ext != "" && path.Ext(originalFileName(rawURL)) == "" {
+		entry.Extension = ext
+	}
+	if rel, err := filepath.Rel(m.directory, file); err == nil {
+		entry.Path = filepath.ToSlash(rel)
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	exists := false
+	if info, err := os.Stat(file); err == nil {
+		entry.Size = info.Size()
+		exists = true
+	}
+
+	// Only newly downloaded files are hashed, to keep re-runs over large archives cheap
+	if status == statusDownloaded || status == statusStub && exists {
+		sum, err := hashFile(file)
+		if err != nil {
+			log.Printf("Failed to hash %s: %s", filepath.Base(file), err)
+		}
+		entry.SHA256 = sum
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode manifest entry: %s", err)
+		return entry.SHA256
+	}
+
+	// Lines are written together at the end of the post, a crash loses at most the lines of one post
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(append(m.pending, line...), '\n')
+	if m.statuses != nil {
+		m.statuses[rawURL] = status
+	}
+	return entry.SHA256
+}
+
+// Writes the pending lines to the file, flushing the file to the disk when sync is set
+func (m *manifest) flush(sync bool) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) == 0 {
+		return
+	}
+	if _, err := m.file.Write(m.pending); err != nil {
+		log.Printf("Failed to write manifest entries: %s", err)
+	}
+	m.pending = m.pending[:0]
+	if sync {
+		if err := m.file.Sync(); err != nil {
+			log.Printf("Failed to flush the manifest: %s", err)
+		}
+	}
+}
+
+// Appends an entry for a listed post whose page was not found, with what the listing showed of it
+func (m *manifest) recordRemovedPost(post post) {
+	if m == nil {
+		return
+	}
+
+	entry := manifestEntry{
+		Time:    time.Now().UTC(),
+		PostID:  post.id,
+		PostURL: post.url,
+		Title:   post.title,
+		Date:    post.published,
+		Status:  statusPostRemoved,
+	}
+	if post.err != nil {
+		entry.Error = post.err.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to encode manifest entry: %s", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(append(m.pending, line...), '\n')
+}
+
+// Closes the manifest file
+func (m *manifest) Close() error {
+	if m == nil {
+		return nil
+	}
+	m.flush(false)
+	return m.file.Close()
+}
+
+// Returns the hex encoded SHA-256 hash of a file
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Encrypted files are hashed by their plaintext
+	var r io.Reader = f
+	if isEncrypted(file) {
+		if r, err = decryptReader(f); err != nil {
+			return "", err
+		}
+	}
+
+	return hashReader(r)
+}
+
+// Returns the hex encoded SHA-256 hash of a file as stored on disk, encrypted files are not decrypted
+func hashStoredFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hashReader(f)
+}
+
+// Returns the hex encoded SHA-256 hash of everything read from the reader
+func hashReader(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
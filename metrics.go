@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Receives the measurements of a run. The default implementation discards
+// everything so runs without a metrics endpoint pay nothing for it.
+type metrics interface {
+	request(status int)
+	fileDownloaded(bytes int64)
+	failure(err error)
+	queueDepth(n int)
+	activeDownloads(delta int)
+}
+
+// Metrics of the current run
+var runMetrics metrics = noMetrics{}
+
+// Metrics implementation discarding all measurements
+type noMetrics struct{}
+
+func (noMetrics) request(int)          {}
+func (noMetrics) fileDownloaded(int64) {}
+func (noMetrics) failure(error)        {}
+func (noMetrics) queueDepth(int)       {}
+func (noMetrics) activeDownloads(int)  {}
+
+// Metrics implementation served in the Prometheus text format
+type promMetrics struct {
+	mu          sync.Mutex
+	requests    map[int]uint64
+	rateLimited uint64
+	files       uint64
+	bytes       uint64
+	failures    map[string]uint64
+	queue       int
+	active      int
+}
+
+func newPromMetrics() *promMetrics {
+	return &promMetrics{requests: map[int]uint64{}, failures: map[string]uint64{}}
+}
+
+func (m *promMetrics) request(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[status]++
+	if status == http.StatusTooManyRequests {
+		m.rateLimited++
+	}
+}
+
+func (m *promMetrics) fileDownloaded(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files++
+	m.bytes += uint64(bytes)
+}
+
+func (m *promMetrics) failure(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[failureClass(err)]++
+}
+
+func (m *promMetrics) queueDepth(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = n
+}
+
+func (m *promMetrics) activeDownloads(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active += delta
+}
+
+// Writes the metrics in the Prometheus text exposition format
+func (m *promMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# TYPE kemono_dl_requests_total counter")
+	statuses := make([]int, 0, len(m.requests))
+	for status := range m.requests {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		label := strconv.Itoa(status)
+		if status == 0 {
+			label = "error"
+		}
+		fmt.Fprintf(w, "kemono_dl_requests_total{status=%q} %d\n", label, m.requests[status])
+	}
+
+	fmt.Fprintln(w, "# TYPE kemono_dl_rate_limited_total counter")
+	fmt.Fprintf(w, "kemono_dl_rate_limited_total %d\n", m.rateLimited)
+	fmt.Fprintln(w, "# TYPE kemono_dl_files_downloaded_total counter")
+	fmt.Fprintf(w, "kemono_dl_files_downloaded_total %d\n", m.files)
+	fmt.Fprintln(w, "# TYPE kemono_dl_bytes_downloaded_total counter")
+	fmt.Fprintf(w, "kemono_dl_bytes_downloaded_total %d\n", m.bytes)
+
+	fmt.Fprintln(w, "# TYPE kemono_dl_failures_total counter")
+	classes := make([]string, 0, len(m.failures))
+	for class := range m.failures {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "kemono_dl_failures_total{class=%q} %d\n", class, m.failures[class])
+	}
+
+	fmt.Fprintln(w, "# TYPE kemono_dl_queue_depth gauge")
+	fmt.Fprintf(w, "kemono_dl_queue_depth %d\n", m.queue)
+	fmt.Fprintln(w, "# TYPE kemono_dl_active_downloads gauge")
+	fmt.Fprintf(w, "kemono_dl_active_downloads %d\n", m.active)
+}
+
+// Returns the class of a failure used as the metrics label
+func failureClass(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrServerError):
+		return "server_error"
+	case errors.Is(err, ErrChallenge):
+		return "challenge"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	case errors.Is(err, ErrStatus):
+		return "status"
+	default:
+		return "other"
+	}
+}
+
+// Starts serving the metrics and the health check on the address
+func serveMetrics(addr string) *http.Server {
+	m := newPromMetrics()
+	runMetrics = m
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Failed to serve metrics: %s", err)
+		}
+	}()
+
+	return server
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The log of the downloads is noise in the test output
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// Response served by the mock site instead of the normal one
+type mockResponse struct {
+	status int
+	header http.Header
+	body   string
+	// Closes the connection after writing half of the body
+	truncate bool
+}
+
+// Mock of the site serving a creator's listing, post pages and files.
+// Every post has an attachment and two images, the files are named after the hash of their content.
+type mockSite struct {
+	*httptest.Server
+	name  string
+	posts int
+
+	mu sync.Mutex
+	// Responses served to the GET requests before the normal ones, by path
+	script map[string][]mockResponse
+	// Requests received, by path and query
+	requests []string
+	headers  []http.Header
+	times    []time.Time
+}
+
+// Starts a mock site with a creator named Bob who has the given number of posts
+func newMockSite(t *testing.T, posts int) *mockSite {
+	t.Helper()
+	site := &mockSite{name: "Bob", posts: posts, script: map[string][]mockResponse{}}
+	site.Server = httptest.NewServer(http.HandlerFunc(site.serve))
+	t.Cleanup(site.Close)
+	return site
+}
+
+// Queues responses served to the GET requests of the path before the normal one
+func (s *mockSite) fail(path string, responses ...mockResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script[path] = append(s.script[path], responses...)
+}
+
+// Returns the number of requests received for the path, with any query
+func (s *mockSite) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, request := range s.requests {
+		if request == path || strings.HasPrefix(request, path+"?") {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the creator's profile on the mock site
+func (s *mockSite) profile() profileConfig {
+	return profileConfig{BaseURL: s.URL, Site: "kemono", Service: "patreon", User: "1"}
+}
+
+func (s *mockSite) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	s.headers = append(s.headers, r.Header.Clone())
+	s.times = append(s.times, time.Now())
+	var scripted *mockResponse
+	if queue := s.script[r.URL.Path]; len(queue) > 0 && r.Method == http.MethodGet {
+		scripted = &queue[0]
+		s.script[r.URL.Path] = queue[1:]
+	}
+	s.mu.Unlock()
+
+	if scripted != nil {
+		for name, values := range scripted.header {
+			w.Header()[name] = values
+		}
+		if scripted.truncate {
+			// Promises the whole body and hangs up halfway through
+			w.Header().Set("Content-Length", strconv.Itoa(len(scripted.body)))
+			w.WriteHeader(scripted.status)
+			io.WriteString(w, scripted.body[:len(scripted.body)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(scripted.status)
+		io.WriteString(w, scripted.body)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/patreon/user/1":
+		writePage(w, r, s.listing(r.URL.Query().Get("o")))
+	case strings.HasPrefix(r.URL.Path, "/patreon/user/1/post/"):
+		id := strings.TrimPrefix(r.URL.Path, "/patreon/user/1/post/")
+		writePage(w, r, s.postPage(id))
+	case strings.HasPrefix(r.URL.Path, "/data/"):
+		content, ok := mockContents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Writes a page compressed with gzip when the client accepts it, like the site
+func writePage(w http.ResponseWriter, r *http.Request, page string) {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		io.WriteString(w, page)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	compressed := gzip.NewWriter(w)
+	io.WriteString(compressed, page)
+	compressed.Close()
+}
+
+// Returns a page of the creator's listing starting at the offset
+func (s *mockSite) listing(offset string) string {
+	o, _ := strconv.Atoi(offset)
+	var page strings.Builder
+	fmt.Fprintf(&page, "<html><body><header><span itemprop='name'>%s</span></header>", s.name)
+	if s.posts > postsPerPage {
+		last := o + postsPerPage
+		if last > s.posts {
+			last = s.posts
+		}
+		fmt.Fprintf(&page, "<div class='paginator'><small>Showing %d - %d of %d</small></div>", o+1, last, s.posts)
+	}
+	for i := o; i < o+postsPerPage && i < s.posts; i++ {
+		id := mockPostID(i)
+		fmt.Fprintf(&page, "<article class='post-card'><a href='/patreon/user/1/post/%s'><header>Post %s</header></a><time datetime='2024-01-02T03:04:05'></time></article>", id, id)
+	}
+	page.WriteString("</body></html>")
+	return page.String()
+}
+
+// Returns the page of a post with an attachment and two images, the first image being the main file
+func (s *mockSite) postPage(id string) string {
+	return fmt.Sprintf(`<html><body>
+<h2>Downloads</h2><ul><li><a class="post__attachment-link" href="%s">Download</a></li></ul>
+<h2>Files</h2><div><a class="fileThumb" href="%s"></a><a class="fileThumb" href="%s"></a></div>
+</body></html>`, mockFile(id+"-zip", "archive.zip"), mockFile(id+"-main", "image.jpg"), mockFile(id+"-second", "image.jpg"))
+}
+
+// Contents of the files served by the mock sites, by path
+var mockContents = map[string][]byte{}
+
+var mockContentsMu sync.Mutex
+
+// Returns the link of a mock file, the path carries the hash of its content like the site's
+func mockFile(seed string, name string) string {
+	content := []byte(strings.Repeat("content of "+seed+"\n", 64))
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	path := fmt.Sprintf("/data/%s/%s/%s%s", hash[:2], hash[2:4], hash, name[strings.LastIndex(name, "."):])
+
+	mockContentsMu.Lock()
+	mockContents[path] = content
+	mockContentsMu.Unlock()
+	return path + "?f=" + name
+}
+
+// Returns the ID of the post at the position in the listing, newest first
+func mockPostID(i int) string {
+	return strconv.Itoa(1000 - i)
+}
+
+// Sets the globals of the run for fast tests and restores them afterwards
+func testRun(t *testing.T) {
+	t.Helper()
+	interval, policy, headers := limiter.interval, *retryConfig, requestHeaders
+	limiter.interval = 0
+	requestHeaders.profile, requestHeaders.extra = headerProfiles["minimal"], http.Header{}
+	*retryConfig = retryPolicy{maxRetries: 3, backoff: time.Millisecond, multiplier: 2, maxWait: 10 * time.Millisecond}
+	retries.resize(0)
+	runFiles.Lock()
+	runFiles.paths = map[string]string{}
+	runFiles.Unlock()
+
+	t.Cleanup(func() {
+		limiter.interval, *retryConfig, requestHeaders = interval, policy, headers
+		retries.resize(retryPoolSize)
+	})
+}
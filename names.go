@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replaces the characters that are not allowed in file names on any of the supported systems
+var fileNameReplacer = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_",
+	"\"", "_", "<", "_", ">", "_", "|", "_",
+)
+
+// Resolves a file link found on a post page against the site's base URL
+func resolveFileURL(baseURL string, href string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Transliterates the file names to ASCII, set by --romanize-filenames
+var romanizeFileNames bool
+
+// Returns the name of the file downloaded from a URL, transliterated to ASCII with --romanize-filenames
+func fileName(rawURL string) string {
+	name := originalFileName(rawURL)
+	if romanizeFileNames {
+		name = romanizeFileName(name)
+	}
+	return name
+}
+
+// Returns the original name of the file downloaded from a URL. The file name
+// carried in the f query parameter is preferred over the hashed basename.
+func originalFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return sanitizeFileName(path.Base(rawURL))
+	}
+
+	if name := sanitizeFileName(u.Query().Get("f")); name != "" {
+		return name
+	}
+
+	return sanitizeFileName(path.Base(u.Path))
+}
+
+// Returns the hashed name the file is stored under on the server
+func hashedFileName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return sanitizeFileName(path.Base(u.Path))
+	}
+	return sanitizeFileName(path.Base(rawURL))
+}
+
+// Makes a file name safe to use on the local filesystem. Names are repaired to valid UTF-8 and
+// normalized to NFC so the same name is written identically on macOS, Linux and Windows.
+func sanitizeFileName(name string) string {
+	return cleanFileName(norm.NFC.String(validText(name)))
+}
+
+// Removes the characters not allowed in file names
+func cleanFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = fileNameReplacer.Replace(name)
+
+	return strings.Trim(name, " .")
+}
+
+// Transliterates a file name to ASCII. Accents are dropped and the remaining
+// non-ASCII characters are replaced, with a short hash of the original name
+// keeping names that differ only in those characters apart.
+func romanizeFileName(name string) string {
+	var b strings.Builder
+	replaced := false
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Combining accents of the decomposed letters
+		case !strings.HasSuffix(b.String(), "_"):
+			b.WriteRune('_')
+			replaced = true
+		}
+	}
+
+	romanized := b.String()
+	if !replaced {
+		return romanized
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	ext := path.Ext(romanized)
+	return strings.TrimSuffix(romanized, ext) + "_" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// Returns the path of a file downloaded under the naming used before the
+// original file names were honoured, normalized or romanized, if such a file exists
+func legacyFilePath(rawURL string, directory string, name string, postID string, exists func(string) bool) (string, bool) {
+	candidates := []string{path.Base(rawURL)}
+	if u, err := url.Parse(rawURL); err == nil {
+		candidates = append(candidates, path.Base(u.Path))
+		if f := u.Query().Get("f"); f != "" {
+			candidates = append(candidates, sanitizeFileName(f), cleanFileName(f))
+		}
+	}
+
+	for _, candidate := range candidates {
+		// Names with invalid byte sequences are never passed to the filesystem
+		if !utf8.ValidString(candidate) {
+			continue
+		}
+		file := fmt.Sprintf("%s/%s_%s_%s", directory, name, postID, candidate)
+		if exists(file) {
+			return file, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"image.jpg", "image.jpg"},
+		{"a/b\\c:d*e?f\"g<h>i|j.png", "a_b_c_d_e_f_g_h_i_j.png"},
+		{"line\nbreak\t.txt", "linebreak.txt"},
+		{"  spaced.zip  ", "spaced.zip"},
+		{"trailing dots...", "trailing dots"},
+		{"..", ""},
+		// Decomposed accents are composed so every system writes the same name
+		{"cafe\u0301.png", "caf\u00e9.png"},
+		{"\u30cf\u309a\u30f3.jpg", "\u30d1\u30f3.jpg"},
+		// Invalid byte sequences never reach the filesystem
+		{"bad\xffname.jpg", "bad�name.jpg"},
+	}
+
+	for _, test := range tests {
+		if got := sanitizeFileName(test.name); got != test.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRomanizeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		// Name before the hash of the original name, or the whole name when nothing was replaced
+		prefix string
+		ext    string
+		hashed bool
+	}{
+		{"image.jpg", "image", ".jpg", false},
+		{"caf\u00e9 cre\u0300me.png", "cafe creme", ".png", false},
+		{"Ångström.txt", "Angstrom", ".txt", false},
+		{"イラスト.png", "_", ".png", true},
+		{"第1話 page.zip", "_1_ page", ".zip", true},
+		{"привет", "_", "", true},
+	}
+
+	for _, test := range tests {
+		got := romanizeFileName(test.name)
+		for _, r := range got {
+			if r >= 0x80 {
+				t.Errorf("romanizeFileName(%q) = %q, not ASCII", test.name, got)
+				break
+			}
+		}
+		if !test.hashed {
+			if got != test.prefix+test.ext {
+				t.Errorf("romanizeFileName(%q) = %q, want %q", test.name, got, test.prefix+test.ext)
+			}
+			continue
+		}
+
+		// {prefix}_{8 hex digits of the hash}{ext}
+		rest := strings.TrimPrefix(got, test.prefix+"_")
+		if rest == got || !strings.HasSuffix(rest, test.ext) || len(strings.TrimSuffix(rest, test.ext)) != 8 {
+			t.Errorf("romanizeFileName(%q) = %q, want %s_{hash}%s", test.name, got, test.prefix, test.ext)
+		}
+	}
+}
+
+func TestRomanizeFileNameKeepsNamesApart(t *testing.T) {
+	// Names differing only in the replaced characters get different hashes
+	a, b := romanizeFileName("猫.jpg"), romanizeFileName("犬.jpg")
+	if a == b {
+		t.Errorf("romanizeFileName gives %q for two different names", a)
+	}
+	if again := romanizeFileName("猫.jpg"); again != a {
+		t.Errorf("romanizeFileName is not stable: %q and %q", a, again)
+	}
+}
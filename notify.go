@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Maximum time the notification helper is allowed to run
+const notifyTimeout = 5 * time.Second
+
+// Shows a native desktop notification. Any failure is ignored, since a missing
+// notification must never affect the outcome of the download.
+func notifyDesktop(title string, message string) {
+	name, args := notifyCommand(title, message)
+
+	// Silently skips the notification when the helper binary isn't present
+	if _, err := exec.LookPath(name); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	_ = exec.CommandContext(ctx, name, args...).Run()
+}
+
+// Totals of the creators handled by a run, or by a check of watch mode, shown in the notification at its end
+type runSummary struct {
+	creators int
+	// Creators that could not be downloaded
+	failed       int
+	files        int
+	failures     int
+	missingPosts int
+	// Reason the run stopped before handling every creator
+	stopped error
+}
+
+// Adds the outcome of a creator to the totals
+func (r *runSummary) add(stats summary, err error) {
+	r.creators++
+	if err != nil && !errors.Is(err, errBudgetExhausted) {
+		r.failed++
+	}
+	r.files += stats.files
+	r.failures += stats.failures
+	r.missingPosts += stats.missingPosts
+}
+
+// Returns the title and message of the notification summing up the run
+func (r runSummary) notification(checkOnly bool) (string, string) {
+	title := "kemono-dl finished"
+	switch {
+	case r.stopped != nil:
+		title = "kemono-dl stopped"
+	case r.failed > 0 || r.failures > 0:
+		title = "kemono-dl finished with failures"
+	}
+
+	var message string
+	if checkOnly {
+		message = fmt.Sprintf("%d creators checked, %d posts with missing files", r.creators, r.missingPosts)
+	} else {
+		message = fmt.Sprintf("%d creators, %d new files, %d failed files", r.creators, r.files, r.failures)
+	}
+	if r.failed > 0 {
+		message += fmt.Sprintf(", %d creators failed", r.failed)
+	}
+	if r.stopped != nil {
+		message += ": " + r.stopped.Error()
+	}
+	return title, message
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !windows
+
+package main
+
+// Returns the notify-send command showing the notification
+func notifyCommand(title string, message string) (string, []string) {
+	return "notify-send", []string{"--app-name=kemono-dl", title, message}
+}
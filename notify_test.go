@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRunNotification(t *testing.T) {
+	tests := []struct {
+		name      string
+		outcomes  []error
+		failures  int
+		stopped   error
+		checkOnly bool
+		title     string
+		message   string
+	}{
+		{"finished", []error{nil, nil}, 0, nil, false, "kemono-dl finished", "2 creators, 6 new files, 0 failed files"},
+		{"failed creator", []error{nil, errors.New("no profile")}, 1, nil, false, "kemono-dl finished with failures", "2 creators, 6 new files, 2 failed files, 1 creators failed"},
+		{"check only", []error{nil}, 0, nil, true, "kemono-dl finished", "1 creators checked, 1 posts with missing files"},
+		{"budget", []error{fmt.Errorf("%w after 1 GiB", errMonthlyBudget)}, 1, errMonthlyBudget, false, "kemono-dl stopped", "1 creators, 3 new files, 1 failed files: run budget exhausted: --monthly-budget reached"},
+		{"no creators", nil, 0, errors.New("interrupted"), false, "kemono-dl stopped", "0 creators, 0 new files, 0 failed files: interrupted"},
+	}
+
+	for _, test := range tests {
+		var run runSummary
+		for _, err := range test.outcomes {
+			run.add(summary{files: 3, failures: test.failures, missingPosts: 1}, err)
+		}
+		run.stopped = test.stopped
+		title, message := run.notification(test.checkOnly)
+		if title != test.title || message != test.message {
+			t.Errorf("%s: notification = %q, %q, want %q, %q", test.name, title, message, test.title, test.message)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PowerShell script showing a toast notification with a title and a message
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('kemono-dl').Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// Returns the PowerShell command showing the notification as a toast
+func notifyCommand(title string, message string) (string, []string) {
+	script := fmt.Sprintf(toastScript, powerShellString(title), powerShellString(message))
+	return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", script}
+}
+
+// Quotes a string for use as a PowerShell single-quoted string literal
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Orders in which the files of a run are downloaded, set by --order
+const (
+	orderNewestFirst   = "newest-first"
+	orderOldestFirst   = "oldest-first"
+	orderSmallestFirst = "smallest-first"
+	orderLargestFirst  = "largest-first"
+)
+
+// Checks the --order value
+func checkOrder(order string) error {
+	switch order {
+	case orderNewestFirst, orderOldestFirst, orderSmallestFirst, orderLargestFirst:
+		return nil
+	}
+	return fmt.Errorf("unknown order %q, expected newest-first, oldest-first, smallest-first or largest-first", order)
+}
+
+// Sizes of the files found by HEAD requests, file URLs carry the content hash so they never change
+var fileSizes = struct {
+	sync.Mutex
+	sizes map[string]int64
+}{sizes: map[string]int64{}}
+
+// Returns the size of a file from a HEAD request, -1 when the site does not report it
+func fileSize(ctx context.Context, url string) int64 {
+	fileSizes.Lock()
+	size, ok := fileSizes.sizes[url]
+	fileSizes.Unlock()
+	if ok {
+		return size
+	}
+
+	size = -1
+	res, err := doRequest(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return size
+	}
+	res.Body.Close()
+	if res.ContentLength >= 0 {
+		size = res.ContentLength
+	}
+
+	fileSizes.Lock()
+	fileSizes.sizes[url] = size
+	fileSizes.Unlock()
+	return size
+}
+
+// A file waiting for its turn in a run ordered by size
+type queuedFile struct {
+	post post
+	file postFile
+	size int64
+}
+
+// Downloads the posts collected from the listing, newest first, in the order of --order
+func downloadOrdered(ctx context.Context, posts []post, c *creator) error {
+	if c.cfg.order == orderOldestFirst {
+		for i := len(posts) - 1; i >= 0; i-- {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Downloading post %d/%d (%d%%): %s", len(posts)-i, len(posts), (len(posts)-i)*100/len(posts), posts[i].url)
+			runMetrics.queueDepth(i)
+
+			err := downloadPost(ctx, posts[i], c)
+			if errors.Is(err, errBudgetExhausted) {
+				return err
+			}
+			if err != nil {
+				log.Printf("Failed to download post: %s", err)
+				c.stats.failures++
+				runMetrics.failure(err)
+			}
+		}
+		return nil
+	}
+
+	// Finds the sizes of the missing files, the files already downloaded are only recorded
+	var queue []queuedFile
+	remaining := map[string]int{}
+	for _, post := range posts {
+		if errors.Is(post.err, ErrNotFound) {
+			c.postRemoved(post)
+			continue
+		}
+		if post.err != nil {
+			log.Printf("Failed to download post: %s", post.err)
+			c.stats.failures++
+			runMetrics.failure(post.err)
+			continue
+		}
+
+		for _, f := range c.postFiles(post) {
+			if f.complete {
+				downloadPostFile(ctx, post, f, c)
+				continue
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			queue = append(queue, queuedFile{post: post, file: f, size: fileSize(withFileTransfer(ctx, post.url), f.url)})
+			remaining[post.url]++
+		}
+		if remaining[post.url] == 0 {
+			if err := finishPost(post, c); err != nil {
+				log.Printf("Failed to download post: %s", err)
+				c.stats.failures++
+			}
+		}
+	}
+
+	// Files of unknown size go last in both orders
+	largest := c.cfg.order == orderLargestFirst
+	sort.SliceStable(queue, func(i, j int) bool {
+		a, b := queue[i].size, queue[j].size
+		if a < 0 || b < 0 {
+			return b < 0 && a >= 0
+		}
+		if largest {
+			return a > b
+		}
+		return a < b
+	})
+
+	for i, queued := range queue {
+		if ctx.Err() != nil {
+			return nil
+		}
+		size := "unknown size"
+		if queued.size >= 0 {
+			size = formatSize(queued.size)
+		}
+		log.Printf("Downloading file %d/%d (%s): %s", i+1, len(queue), size, queued.file.url)
+		runMetrics.queueDepth(len(queue) - i - 1)
+
+		if err := downloadPostFile(ctx, queued.post, queued.file, c); err != nil {
+			return err
+		}
+
+		// Runs the post hook once the last file of the post is done
+		remaining[queued.post.url]--
+		if remaining[queued.post.url] == 0 {
+			if err := finishPost(queued.post, c); err != nil {
+				log.Printf("Failed to download post: %s", err)
+				c.stats.failures++
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Directory in the creator's directory holding the unfinished downloads, named after the file on the server
+// so a download is resumed whatever name the file gets locally
+const partialDirName = ".partial"
+
+// Returns the path the file downloaded from the URL into the destination is kept at until it is complete
+func partialPath(url string, dest string) string {
+	return filepath.Join(filepath.Dir(dest), partialDirName, hashedFileName(url))
+}
+
+// Removes the unfinished downloads not touched for longer than the maximum age
+func cleanPartials(dir string, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, partialDirName))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// The progress of a chunked download is removed with the download
+		file := filepath.Join(dir, partialDirName, entry.Name())
+		if download, ok := strings.CutSuffix(file, chunkStateSuffix); ok {
+			if _, err := os.Stat(download); err == nil {
+				continue
+			}
+		}
+
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Printf("Failed to remove the unfinished download %s: %s", entry.Name(), err)
+			continue
+		}
+		os.Remove(file + chunkStateSuffix)
+		log.Printf("Removed the unfinished download %s (%s), not resumed for %s", entry.Name(), formatSize(info.Size()), time.Since(info.ModTime()).Round(time.Hour))
+	}
+}
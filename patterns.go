@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Glob patterns matched against the file names, set by the repeatable --ignore-pattern and --include-pattern
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *patternList) Set(value string) error {
+	if err := checkPattern(value); err != nil {
+		return err
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// Checks the syntax of a glob pattern
+func checkPattern(pattern string) error {
+	if _, err := path.Match(globPattern(pattern), ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Returns the pattern in path.Match syntax. File names have no separators,
+// so ** matches the same as *.
+func globPattern(pattern string) string {
+	for strings.Contains(pattern, "**") {
+		pattern = strings.ReplaceAll(pattern, "**", "*")
+	}
+	return pattern
+}
+
+// Reports whether the name matches any of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(globPattern(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Name patterns selecting the files of a creator which are downloaded
+type nameFilter struct {
+	ignore  []string
+	include []string
+}
+
+// Reports whether the file with the name is left out, the ignore patterns win over the include patterns
+func (f nameFilter) skips(name string) bool {
+	if matchesAny(f.ignore, name) {
+		return true
+	}
+	return len(f.include) > 0 && !matchesAny(f.include, name)
+}
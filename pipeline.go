@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A post with the media files extracted from its page
+type post struct {
+	url   string
+	id    string
+	files []string
+	// Positions of the files in the post, the main file is 0 and the attachments follow
+	indexes []int
+	// Title and publication date shown in the creator's listing
+	title     string
+	published string
+	err       error
+}
+
+// Number of posts on a full page of the creator's listing
+const postsPerPage = 50
+
+// A post as shown in the creator's listing, the title and date are kept for posts whose page is gone
+type listedPost struct {
+	link      string
+	title     string
+	published string
+}
+
+// Posts on a single page of the creator's listing
+type listingPage struct {
+	links []listedPost
+	err   error
+}
+
+// Streams the links of all posts from the creator's pages as the pages arrive.
+// The next page is fetched while the posts of the current one are handed downstream.
+// The error channel receives a single value once the producer is done.
+func streamPosts(ctx context.Context, url string, total int) (<-chan listedPost, <-chan error) {
+	links := make(chan listedPost)
+	errc := make(chan error, 1)
+
+	// Fetches the pages one ahead of the posts being handed out
+	pages := make(chan listingPage, 1)
+	go func() {
+		defer close(pages)
+
+		// Iterates through every page and extracts all posts
+		for i := 0; i < numberOfPages(total); i++ {
+			page := fmt.Sprintf("%s?o=%d", url, i*postsPerPage)
+			log.Println(page)
+			doc, err := fetchDocument(ctx, page)
+
+			// Searches for the post links in the HTML
+			var posts []listedPost
+			if err == nil {
+				doc.Find("article.post-card").Each(func(i int, selection *goquery.Selection) {
+					postUrl, _ := selection.Find("a").Attr("href")
+					published, _ := selection.Find("time").Attr("datetime")
+					posts = append(posts, listedPost{
+						link:      postUrl,
+						title:     strings.TrimSpace(validText(selection.Find("header").Text())),
+						published: published,
+					})
+				})
+			}
+
+			select {
+			case pages <- listingPage{links: posts, err: err}:
+			case <-ctx.Done():
+				return
+			}
+
+			// A short page is the last one, there is nothing to prefetch after it
+			if err != nil || len(posts) < postsPerPage {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(links)
+		defer close(errc)
+
+		for page := range pages {
+			if page.err != nil {
+				errc <- page.err
+				return
+			}
+
+			for _, post := range page.links {
+				select {
+				case links <- post:
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			}
+		}
+
+		errc <- ctx.Err()
+	}()
+
+	return links, errc
+}
+
+// Fetches the pages of the streamed posts with up to the given number of concurrent requests.
+// The posts are delivered in the order of the links regardless of which page arrives first.
+func fetchPosts(ctx context.Context, links <-chan listedPost, baseURL string, workers int) <-chan post {
+	if workers < 1 {
+		workers = 1
+	}
+	posts := make(chan post)
+
+	// Queues a result for every link in order, limiting how many posts are fetched ahead
+	pending := make(chan chan post, workers-1)
+	go func() {
+		defer close(pending)
+
+		for link := range links {
+			result := make(chan post, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(listed listedPost) {
+				post := fetchPost(ctx, baseURL+listed.link)
+				post.title, post.published = listed.title, listed.published
+				result <- post
+			}(link)
+		}
+	}()
+
+	go func() {
+		defer close(posts)
+
+		for result := range pending {
+			post := <-result
+
+			select {
+			case posts <- post:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return posts
+}
+
+// Fetches a post page and extracts the links to its media files
+func fetchPost(ctx context.Context, url string) post {
+	id, err := postID(url)
+	if err != nil {
+		return post{url: url, err: err}
+	}
+
+	doc, err := fetchDocument(ctx, url)
+	if err != nil {
+		return post{url: url, id: id, err: err}
+	}
+
+	var files []string
+	var indexes []int
+	// Extracts the media URLs from the Downloads section of the post
+	doc.Find("h2:contains('Downloads')").Next().Find("a.post__attachment-link").Each(func(i int, selection *goquery.Selection) {
+		file, exists := selection.Attr("href")
+		if exists {
+			files = append(files, file)
+			indexes = append(indexes, len(indexes)+1)
+		}
+	})
+
+	// Extracts the media URLs from the Files section of the post, the first one is the main file
+	attachments := len(files)
+	doc.Find("h2:contains('Files')").Next().Find("a.fileThumb").Each(func(i int, selection *goquery.Selection) {
+		file, exists := selection.Attr("href")
+		if exists {
+			if len(files) == attachments {
+				indexes = append(indexes, 0)
+			} else {
+				indexes = append(indexes, len(indexes))
+			}
+			files = append(files, file)
+		}
+	})
+
+	return post{url: url, id: id, files: files, indexes: indexes}
+}
+
+// Extracts the post ID from a post URL
+func postID(rawURL string) (string, error) {
+	// Matches the post's id from the url using regex
+	regex := regexp.MustCompile(`.*\/\w+\/post\/(\d+)(?:[\/?#]|$)`)
+	if match := regex.FindStringSubmatch(rawURL); match != nil {
+		return match[1], nil
+	}
+
+	// Falls back to the path segment following "post" for IDs that are not numeric
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse post url %s: %w", rawURL, err)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == "post" && segments[i+1] != "" {
+			return segments[i+1], nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find the post id in %s", rawURL)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamPostsPagination(t *testing.T) {
+	for _, posts := range []int{0, 1, 50, 51, 120} {
+		t.Run(fmt.Sprint(posts), func(t *testing.T) {
+			testRun(t)
+			site := newMockSite(t, posts)
+			url := site.profile().URL()
+
+			total, err := numberOfPosts(context.Background(), url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != posts {
+				t.Fatalf("numberOfPosts = %d, want %d", total, posts)
+			}
+
+			links, errc := streamPosts(context.Background(), url, total)
+			var got []listedPost
+			for link := range links {
+				got = append(got, link)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != posts {
+				t.Fatalf("streamed %d posts, want %d", len(got), posts)
+			}
+			for i, link := range got {
+				if want := "/patreon/user/1/post/" + mockPostID(i); link.link != want {
+					t.Fatalf("post %d is %s, want %s", i, link.link, want)
+				}
+			}
+			for page := 0; page < numberOfPages(posts); page++ {
+				if n := site.count(fmt.Sprintf("/patreon/user/1?o=%d", page*postsPerPage)); n != 1 {
+					t.Errorf("page at offset %d was fetched %d times", page*postsPerPage, n)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchPost(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+
+	p := fetchPost(context.Background(), site.URL+"/patreon/user/1/post/1000")
+	if p.err != nil {
+		t.Fatal(p.err)
+	}
+	if p.id != "1000" {
+		t.Errorf("id = %q, want 1000", p.id)
+	}
+	if len(p.files) != 3 {
+		t.Fatalf("found %d files, want 3: %v", len(p.files), p.files)
+	}
+	// The attachment comes first, the main file is at position 0
+	if want := []int{1, 0, 2}; fmt.Sprint(p.indexes) != fmt.Sprint(want) {
+		t.Errorf("indexes = %v, want %v", p.indexes, want)
+	}
+}
+
+func TestFetchPostNotFound(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	site.fail("/patreon/user/1/post/1000", mockResponse{status: 404, body: "gone"})
+
+	p := fetchPost(context.Background(), site.URL+"/patreon/user/1/post/1000")
+	if !errors.Is(p.err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", p.err)
+	}
+	if n := site.count("/patreon/user/1/post/1000"); n != 1 {
+		t.Errorf("a missing post was requested %d times", n)
+	}
+}
+
+func TestPostID(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://kemono.su/patreon/user/1/post/12345", "12345"},
+		{"https://kemono.su/patreon/user/1/post/12345/", "12345"},
+		{"https://kemono.su/patreon/user/1/post/12345?o=50", "12345"},
+		{"/patreon/user/1/post/12345", "12345"},
+		{"https://kemono.su/gumroad/user/name/post/AbCdE", "AbCdE"},
+		{"https://kemono.su/discord/server/1/post/x-y_z", "x-y_z"},
+		{"https://coomer.su/onlyfans/user/name/post/1a2b3c", "1a2b3c"},
+	}
+	for _, test := range tests {
+		got, err := postID(test.url)
+		if err != nil || got != test.want {
+			t.Errorf("postID(%q) = %q, %v, want %q", test.url, got, err, test.want)
+		}
+	}
+}
+
+func TestPostIDInvalid(t *testing.T) {
+	// Each of these used to panic on the unmatched regular expression
+	for _, url := range []string{
+		"",
+		"https://kemono.su/patreon/user/1",
+		"https://kemono.su/patreon/user/1/post/",
+		"https://kemono.su/post",
+		"%zz",
+	} {
+		if got, err := postID(url); err == nil {
+			t.Errorf("postID(%q) = %q, want an error", url, got)
+		}
+	}
+}
+
+func TestStreamPostsSinglePage(t *testing.T) {
+	testRun(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	links, errc := streamPosts(context.Background(), srv.URL+"/listing_single.html", 3)
+	var got []listedPost
+	for link := range links {
+		got = append(got, link)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []listedPost{
+		{"/patreon/user/1/post/300", "Third post", "2024-03-01T10:00:00"},
+		{"/patreon/user/1/post/200", "Second post", "2024-02-01T10:00:00"},
+		{"/patreon/user/1/post/100", "First post", "2024-01-01T10:00:00"},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("streamed %v, want %v", got, want)
+	}
+}
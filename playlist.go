@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the playlist of the downloaded videos in the creator's directory
+const playlistName = "playlist.m3u8"
+
+// Writes playlist.m3u8 listing the downloaded videos of the creator, oldest post first.
+// The entries are relative to the creator's directory so the playlist keeps working after moving it.
+func generatePlaylist(dir string, prefix string) (int, error) {
+	local, err := localPosts(dir, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkEncrypted(dir, local); err != nil {
+		return 0, err
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+
+	count := 0
+	ids := newestPosts(local)
+	for i := len(ids) - 1; i >= 0; i-- {
+		files := local[ids[i]]
+		sort.Strings(files)
+
+		for _, name := range files {
+			name = plainName(name)
+			if !videoExtensions[strings.ToLower(filepath.Ext(name))] {
+				continue
+			}
+			fmt.Fprintf(&playlist, "#EXTINF:-1,Post %s - %s\n%s\n", ids[i], name, name)
+			count++
+		}
+	}
+
+	_, err = writeMetadata(filepath.Join(dir, playlistName), []byte(playlist.String()))
+	return count, err
+}
+
+// Generates the playlist of an existing creator directory without accessing the site
+func playlistCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: kemono-dl playlist DIR")
+	}
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	count, err := generatePlaylist(dir, directoryPrefix(dir))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Wrote %d videos to %s", count, filepath.Join(dir, playlistName))
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Location of a creator on one of the supported sites
+type profileConfig struct {
+	// Scheme and host of the site as provided by the user, e.g. https://kemono.su
+	BaseURL string
+	// Name of the site, kemono or coomer
+	Site string
+	// Service the creator publishes on, e.g. patreon
+	Service string
+	// ID of the creator on the service
+	User string
+}
+
+// Domains of the supported sites
+var siteDomains = map[string]string{
+	"kemono.party": "kemono",
+	"kemono.su":    "kemono",
+	"coomer.party": "coomer",
+	"coomer.su":    "coomer",
+}
+
+// Matches the service and the user ID at the start of a creator or post path
+var profilePath = regexp.MustCompile(`^/([^/]+)/user/([^/]+)`)
+
+// Parses a creator or post URL of one of the supported sites.
+// Query strings, fragments, trailing slashes and post paths are ignored.
+func parseProfileURL(raw string) (profileConfig, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return profileConfig{}, err
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return profileConfig{}, errors.New("url must start with https://")
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	site, ok := siteDomains[host]
+	if !ok {
+		return profileConfig{}, fmt.Errorf("unsupported site %s", u.Host)
+	}
+
+	match := profilePath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return profileConfig{}, errors.New("url does not point to a creator")
+	}
+
+	if err := checkService(site, match[1], match[2]); err != nil {
+		return profileConfig{}, err
+	}
+
+	return profileConfig{
+		BaseURL: "https://" + host,
+		Site:    site,
+		Service: match[1],
+		User:    match[2],
+	}, nil
+}
+
+// Current domains of the sites, used when only the site's name is given
+var defaultDomains = map[string]string{
+	"kemono": "kemono.su",
+	"coomer": "coomer.su",
+}
+
+// Builds the location of a creator from the IDs given with --service, --user and --site.
+// The site may be a domain or a site name, it defaults to the site mirroring the service.
+func profileFromIDs(site string, service string, user string) (profileConfig, error) {
+	if service == "" || user == "" {
+		return profileConfig{}, errors.New("both --service and --user are required")
+	}
+	quirks, ok := services[service]
+	if !ok {
+		return profileConfig{}, fmt.Errorf("unknown service %q, see --list-services", service)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(site, "/"))
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host = strings.TrimPrefix(host, "www.")
+	if host == "" {
+		host = quirks.site
+	}
+	if domain, ok := defaultDomains[host]; ok {
+		host = domain
+	}
+	name, ok := siteDomains[host]
+	if !ok {
+		return profileConfig{}, fmt.Errorf("unsupported site %s", site)
+	}
+
+	if err := checkService(name, service, user); err != nil {
+		return profileConfig{}, err
+	}
+
+	return profileConfig{
+		BaseURL: "https://" + host,
+		Site:    name,
+		Service: service,
+		User:    user,
+	}, nil
+}
+
+// Returns the URL of the creator's page
+func (p profileConfig) URL() string {
+	return fmt.Sprintf("%s/%s/user/%s", p.BaseURL, p.Service, p.User)
+}
+
+// Returns the directory of a creator in the base directory, "{site}/{service}/{name} [{id}]".
+// An existing directory carrying the creator's ID is reused when the creator was renamed.
+// The directories created before the service was part of the path, "{site}/{name} [{id}]"
+// and "{site}/{name}", are reused when their profile.json belongs to the same service.
+// The second value is the name the existing files of the creator are prefixed with.
+func creatorDirectory(base string, profile profileConfig, name string) (string, string) {
+	raw := name
+	name = sanitizeFileName(name)
+	if name == "" {
+		name = sanitizeFileName(profile.User)
+	}
+	suffix := fmt.Sprintf(" [%s]", sanitizeFileName(profile.User))
+	siteDir := filepath.Join(base, profile.Site)
+	serviceDir := filepath.Join(siteDir, sanitizeFileName(profile.Service))
+
+	if dir, prefix, ok := findCreatorDirectory(serviceDir, name, suffix, nil); ok {
+		return dir, prefix
+	}
+
+	// Creators of different services may share an ID, only their own directory is reused
+	sameService := func(dir string) bool {
+		saved, ok := readProfile(dir)
+		return ok && saved.Service == profile.Service
+	}
+	if dir, prefix, ok := findCreatorDirectory(siteDir, name, suffix, sameService); ok {
+		return dir, prefix
+	}
+
+	// Directories from before the profile was saved have none. The first versions named them and
+	// prefixed the files with the name as shown on the site, unless it spans several directories.
+	legacyNames := []string{name}
+	if raw != name && raw != "" && raw != "." && raw != ".." && !strings.ContainsAny(raw, `/\`) {
+		legacyNames = append(legacyNames, raw)
+	}
+	for _, legacyName := range legacyNames {
+		legacy := filepath.Join(siteDir, legacyName)
+		if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+			if _, err := os.Stat(filepath.Join(legacy, profileName)); os.IsNotExist(err) || sameService(legacy) {
+				return legacy, legacyName
+			}
+		}
+	}
+
+	return filepath.Join(serviceDir, name+suffix), name
+}
+
+// Finds the directory of the creator among the "{name} [{id}]" directories in the parent
+// directory, belongs tells the creator's directories apart when the ID is not unique
+func findCreatorDirectory(parent string, name string, suffix string, belongs func(string) bool) (string, string, bool) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return "", "", false
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		dir := filepath.Join(parent, entry.Name())
+		if belongs != nil && !belongs(dir) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(entry.Name(), suffix)
+		if prefix != name {
+			log.Printf("Creator %s was previously named %s, reusing %s", name, prefix, entry.Name())
+		}
+		return dir, prefix, true
+	}
+	return "", "", false
+}
+
+// Returns the names of the supported sites
+func siteNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, site := range siteDomains {
+		if !seen[site] {
+			seen[site] = true
+			names = append(names, site)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the creator directories in the base directory relative to it, "{site}/{service}/{name} [{id}]"
+// and the "{site}/{name} [{id}]" directories created before the service was part of the path
+func creatorDirectories(base string) ([]string, error) {
+	var dirs []string
+	for _, site := range siteNames() {
+		entries, err := os.ReadDir(filepath.Join(base, site))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join(site, entry.Name())
+			if !serviceDirectory(filepath.Join(base, dir)) {
+				dirs = append(dirs, dir)
+				continue
+			}
+
+			creators, err := os.ReadDir(filepath.Join(base, dir))
+			if err != nil {
+				return nil, err
+			}
+			for _, creator := range creators {
+				if creator.IsDir() {
+					dirs = append(dirs, filepath.Join(dir, creator.Name()))
+				}
+			}
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// Reports whether a directory in a site directory holds the creators of a service
+// rather than being the directory of a creator
+func serviceDirectory(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, profileName)); err == nil {
+		return false
+	}
+	if _, ok := services[filepath.Base(dir)]; ok {
+		return true
+	}
+
+	// Services missing from the table are recognized by the creators they hold
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), profileName)); entry.IsDir() && err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProfileURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want profileConfig
+	}{
+		{"https://kemono.su/patreon/user/12345", profileConfig{"https://kemono.su", "kemono", "patreon", "12345"}},
+		{"https://kemono.su/patreon/user/12345/", profileConfig{"https://kemono.su", "kemono", "patreon", "12345"}},
+		{"https://kemono.su/patreon/user/12345?o=50", profileConfig{"https://kemono.su", "kemono", "patreon", "12345"}},
+		{"https://kemono.su/fanbox/user/12345/post/678#comments", profileConfig{"https://kemono.su", "kemono", "fanbox", "12345"}},
+		{"https://www.kemono.su/fantia/user/12345", profileConfig{"https://kemono.su", "kemono", "fantia", "12345"}},
+		{"http://KEMONO.SU/patreon/user/12345", profileConfig{"https://kemono.su", "kemono", "patreon", "12345"}},
+		{"https://kemono.party/patreon/user/12345", profileConfig{"https://kemono.party", "kemono", "patreon", "12345"}},
+		{"  https://kemono.su/gumroad/user/some.name  ", profileConfig{"https://kemono.su", "kemono", "gumroad", "some.name"}},
+		{"https://kemono.su/dlsite/user/RG12345", profileConfig{"https://kemono.su", "kemono", "dlsite", "RG12345"}},
+		{"https://coomer.su/onlyfans/user/some_name", profileConfig{"https://coomer.su", "coomer", "onlyfans", "some_name"}},
+		{"https://coomer.party/onlyfans/user/some-name/post/123", profileConfig{"https://coomer.party", "coomer", "onlyfans", "some-name"}},
+		{"https://coomer.su/fansly/user/123456?o=100", profileConfig{"https://coomer.su", "coomer", "fansly", "123456"}},
+	}
+	for _, test := range tests {
+		got, err := parseProfileURL(test.url)
+		if err != nil {
+			t.Errorf("parseProfileURL(%q): %s", test.url, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseProfileURL(%q) = %+v, want %+v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestParseProfileURLInvalid(t *testing.T) {
+	for _, url := range []string{
+		"",
+		"kemono.su/patreon/user/12345",
+		"ftp://kemono.su/patreon/user/12345",
+		"https://example.com/patreon/user/12345",
+		"https://kemono.su/",
+		"https://kemono.su/patreon/12345",
+		"https://kemono.su/patreon/user/abc",
+		"https://kemono.su/onlyfans/user/12345",
+		"https://coomer.su/patreon/user/12345",
+		"https://kemono.su/dlsite/user/12345",
+	} {
+		if got, err := parseProfileURL(url); err == nil {
+			t.Errorf("parseProfileURL(%q) = %+v, want an error", url, got)
+		}
+	}
+}
+
+func TestCreatorDirectory(t *testing.T) {
+	base := t.TempDir()
+	patreon := profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "patreon", User: "123"}
+	fanbox := profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "fanbox", User: "123"}
+
+	dir, prefix := creatorDirectory(base, patreon, "Bob")
+	if want := filepath.Join(base, "kemono", "patreon", "Bob [123]"); dir != want || prefix != "Bob" {
+		t.Fatalf("new directory = %s, %s, want %s", dir, prefix, want)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveProfile(dir, patreon, "Bob", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A renamed creator keeps the directory and the prefix of the files
+	if got, prefix := creatorDirectory(base, patreon, "Robert"); got != dir || prefix != "Bob" {
+		t.Errorf("renamed creator got %s, %s, want %s, Bob", got, prefix, dir)
+	}
+
+	// Another service's creator with the same ID gets a directory of their own
+	if got, _ := creatorDirectory(base, fanbox, "Bob"); got == dir {
+		t.Errorf("fanbox creator shares the patreon directory %s", got)
+	}
+
+	dirs, err := creatorDirectories(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != filepath.Join("kemono", "patreon", "Bob [123]") {
+		t.Errorf("creatorDirectories = %v", dirs)
+	}
+}
+
+func TestCreatorDirectoryBeforeServices(t *testing.T) {
+	base := t.TempDir()
+	patreon := profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "patreon", User: "123"}
+	fanbox := profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "fanbox", User: "123"}
+
+	// An archive created before the service was part of the path
+	old := filepath.Join(base, "kemono", "Bob [123]")
+	if err := os.MkdirAll(old, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveProfile(old, patreon, "Bob", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := creatorDirectory(base, patreon, "Bob"); got != old {
+		t.Errorf("patreon creator got %s, want the existing %s", got, old)
+	}
+	if got, _ := creatorDirectory(base, fanbox, "Bob"); got != filepath.Join(base, "kemono", "fanbox", "Bob [123]") {
+		t.Errorf("fanbox creator got %s, want a directory of their own", got)
+	}
+
+	dirs, err := creatorDirectories(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != filepath.Join("kemono", "Bob [123]") {
+		t.Errorf("creatorDirectories = %v", dirs)
+	}
+}
+
+func TestCreatorDirectoryUnsanitizedName(t *testing.T) {
+	base := t.TempDir()
+	patreon := profileConfig{BaseURL: "https://kemono.su", Site: "kemono", Service: "patreon", User: "123"}
+	name := "Bob: the *Artist*?"
+	if sanitizeFileName(name) == name {
+		t.Fatalf("%q needs no sanitizing", name)
+	}
+
+	// The first versions named the directory and the files after the name as shown on the site
+	old := filepath.Join(base, "kemono", name)
+	if err := os.MkdirAll(old, 0755); err != nil {
+		t.Skipf("the filesystem does not allow the name: %s", err)
+	}
+	if got, prefix := creatorDirectory(base, patreon, name); got != old || prefix != name {
+		t.Errorf("creator got %s, %s, want the existing %s, %s", got, prefix, old, name)
+	}
+
+	// Names spanning several directories are not looked for
+	nested := "Bob/Art"
+	if err := os.MkdirAll(filepath.Join(base, "kemono", "Bob", "Art"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := creatorDirectory(base, patreon, nested); got == filepath.Join(base, "kemono", "Bob", "Art") {
+		t.Errorf("creator got the nested directory %s", got)
+	}
+}
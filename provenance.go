@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Extended attributes recording where a downloaded file came from,
+// the URL attributes follow the freedesktop.org convention also used by browsers and yt-dlp
+var provenanceAttributes = []struct {
+	name  string
+	label string
+}{
+	{"user.xdg.origin.url", "URL"},
+	{"user.xdg.referrer.url", "Post"},
+	{"user.kemono-dl.time", "Downloaded"},
+	{"user.kemono-dl.sha256", "SHA-256"},
+}
+
+// Writes the download URL, post URL, download time and hash into the extended attributes of the file
+func writeProvenance(file string, url string, postURL string, sum string) error {
+	if sum == "" {
+		var err error
+		if sum, err = hashFile(file); err != nil {
+			return err
+		}
+	}
+
+	values := []string{url, postURL, time.Now().UTC().Format(time.RFC3339), sum}
+	for i, attr := range provenanceAttributes {
+		if values[i] == "" {
+			continue
+		}
+		if err := setXattr(file, attr.name, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prints where the given downloaded files came from, using their extended
+// attributes and the manifest in their directory
+func lookupCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: kemono-dl lookup FILE...")
+	}
+
+	for i, file := range args {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(file)
+
+		found := false
+		for _, attr := range provenanceAttributes {
+			if value, err := getXattr(file, attr.name); err == nil {
+				fmt.Printf("  %-11s %s\n", attr.label+":", value)
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+
+		entry, err := lookupManifest(file)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			fmt.Println("  No provenance recorded")
+			continue
+		}
+
+		fmt.Printf("  %-11s %s\n", "URL:", entry.URL)
+		if entry.Host != "" {
+			fmt.Printf("  %-11s %s\n", "Host:", entry.Host)
+		}
+		if entry.PostURL != "" {
+			fmt.Printf("  %-11s %s\n", "Post:", entry.PostURL)
+		} else {
+			fmt.Printf("  %-11s %s\n", "Post ID:", entry.PostID)
+		}
+		fmt.Printf("  %-11s %s\n", "Downloaded:", entry.Time.Format(time.RFC3339))
+		if entry.SHA256 != "" {
+			fmt.Printf("  %-11s %s\n", "SHA-256:", entry.SHA256)
+		}
+	}
+
+	return nil
+}
+
+// Returns the latest download of the file recorded in the manifest of its directory,
+// or nil when the manifest has none
+func lookupManifest(file string) (*manifestEntry, error) {
+	f, err := os.Open(filepath.Join(filepath.Dir(file), manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(file)
+	var found *manifestEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Path == name && entry.Status == statusDownloaded {
+			found = &entry
+		}
+	}
+
+	return found, scanner.Err()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the report listing the removed posts in the creator's directory
+const removedPostsName = "removed_posts.json"
+
+// Name of the quarantine directory the files of removed posts are moved into
+const removedDirName = "_removed"
+
+// Version of the removed_posts.json format written by this version, reports
+// without a version are from before the format was versioned and hold only the posts
+const removedPostsSchema = 1
+
+// Report of the downloaded posts that no longer exist on the site
+type removedPostsReport struct {
+	Schema int           `json:"_schema"`
+	Posts  []removedPost `json:"posts"`
+}
+
+// A downloaded post which no longer exists on the site
+type removedPost struct {
+	PostID string   `json:"post_id"`
+	Reason string   `json:"reason"`
+	Files  []string `json:"files"`
+}
+
+// Returns the downloaded files in the creator's directory grouped by post ID
+func localPosts(directory string, prefix string) (map[string][]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	posts := map[string][]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix+"_") {
+			continue
+		}
+
+		// Files are named {prefix}_{postID}_{file}
+		rest := strings.TrimPrefix(entry.Name(), prefix+"_")
+		id, _, ok := strings.Cut(rest, "_")
+		if !ok || id == "" {
+			continue
+		}
+		posts[id] = append(posts[id], entry.Name())
+	}
+
+	return posts, nil
+}
+
+// Returns the IDs of the downloaded posts which no longer exist on the site with the reason.
+// Posts missing from the listing are only returned when the listing was complete.
+func removedPostIDs(local map[string][]string, seen map[string]bool, notFound []string, complete bool) map[string]string {
+	reasons := map[string]string{}
+	if complete {
+		for id := range local {
+			if !seen[id] {
+				reasons[id] = "missing from the creator's posts"
+			}
+		}
+	}
+	for _, id := range notFound {
+		reasons[id] = "post page not found"
+	}
+	return reasons
+}
+
+// Writes the report of downloaded posts that no longer exist on the site and,
+// with --prune-delete, moves their files into the quarantine directory.
+// Posts missing from the listing are only reported when the listing was complete.
+func prune(c *creator, seen map[string]bool, notFound []string, complete bool) error {
+	local, err := localPosts(c.directory, c.prefix)
+	if err != nil {
+		return err
+	}
+
+	removed := []removedPost{}
+	for id, reason := range removedPostIDs(local, seen, notFound, complete) {
+		files := local[id]
+		if files == nil {
+			files = []string{}
+		}
+		sort.Strings(files)
+		removed = append(removed, removedPost{PostID: id, Reason: reason, Files: files})
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].PostID < removed[j].PostID
+	})
+
+	file := filepath.Join(c.directory, removedPostsName)
+	if err := checkSchema(file, removedPostsSchema); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(removedPostsReport{Schema: removedPostsSchema, Posts: removed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := writeMetadata(file, data); err != nil {
+		return err
+	}
+	log.Printf("%d downloaded posts no longer exist on the site", len(removed))
+
+	if !c.cfg.pruneDelete {
+		return nil
+	}
+
+	// Quarantines the files instead of deleting them
+	quarantine := filepath.Join(c.directory, removedDirName)
+	for _, post := range removed {
+		for _, file := range post.Files {
+			if err := os.MkdirAll(quarantine, 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(filepath.Join(c.directory, file), filepath.Join(quarantine, file)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
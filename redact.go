@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query parameters masked in the log unless --redact-params is set
+const defaultRedactedParams = "token,key,auth,session,sig,signature,access_token,api_key"
+
+// Text replacing the masked values
+const redactedValue = "REDACTED"
+
+// URLs in the log lines, up to the quotes Go puts around them in errors
+var logURLPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// Writer of the log masking the secrets before they reach the terminal or a shared file
+type redactor struct {
+	mu  sync.Mutex
+	out io.Writer
+	// Lower-case names of the masked query parameters
+	params map[string]bool
+	// Values masked wherever they appear, such as the --header values
+	secrets []string
+	// Local paths shortened with --redact-paths, mapped to their replacement
+	paths [][2]string
+	// Log of the run kept for --debug-bundle
+	captured *bytes.Buffer
+}
+
+// Builds the redactor of the log from the options
+func newRedactor(out io.Writer, params string, headers headerList, redactPaths bool) *redactor {
+	r := &redactor{out: out, params: map[string]bool{}}
+	for _, param := range strings.Split(params, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			r.params[strings.ToLower(param)] = true
+		}
+	}
+
+	// Masks the header values and each cookie of a Cookie header
+	for _, header := range headers {
+		_, value, _ := strings.Cut(header, ": ")
+		r.addSecret(value)
+		for _, cookie := range strings.Split(value, ";") {
+			if _, v, ok := strings.Cut(cookie, "="); ok {
+				r.addSecret(v)
+			}
+		}
+	}
+
+	if redactPaths {
+		if wd, err := os.Getwd(); err == nil {
+			r.paths = append(r.paths, [2]string{wd + string(filepath.Separator), ""}, [2]string{wd, "."})
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			r.paths = append(r.paths, [2]string{home, "~"})
+		}
+	}
+
+	return r
+}
+
+// Masks the value wherever it appears, values too short to be secrets are ignored
+func (r *redactor) addSecret(value string) {
+	if value = strings.TrimSpace(value); len(value) >= 4 {
+		r.secrets = append(r.secrets, value)
+	}
+}
+
+// Returns the line with the secrets, the denied query parameters and the local paths masked
+func (r *redactor) redact(line string) string {
+	for _, secret := range r.secrets {
+		line = strings.ReplaceAll(line, secret, redactedValue)
+	}
+
+	line = logURLPattern.ReplaceAllStringFunc(line, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return raw
+		}
+
+		changed := false
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+			changed = true
+		}
+		query := u.Query()
+		for name := range query {
+			if r.params[strings.ToLower(name)] {
+				query.Set(name, redactedValue)
+				changed = true
+			}
+		}
+		if !changed {
+			return raw
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	})
+
+	for _, path := range r.paths {
+		line = strings.ReplaceAll(line, path[0], path[1])
+	}
+	return line
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	line := r.redact(string(p))
+	if r.captured != nil {
+		r.captured.WriteString(line)
+	}
+	if _, err := io.WriteString(r.out, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writes a zip with the redacted log, the options and the summary of the run, for bug reports
+func (r *redactor) writeDebugBundle(file string, summary string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if f.Name == "header" || f.Name == "encrypt-key" {
+			value = redactedValue
+		}
+		fmt.Fprintf(&config, "--%s=%s\n", f.Name, r.redact(value))
+	})
+	for _, arg := range flag.Args() {
+		fmt.Fprintln(&config, r.redact(arg))
+	}
+
+	var content bytes.Buffer
+	archive := zip.NewWriter(&content)
+	for _, entry := range []struct{ name, data string }{
+		{"log.txt", r.captured.String()},
+		{"config.txt", config.String()},
+		{"summary.txt", r.redact(summary)},
+	} {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, entry.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, content.Bytes(), 0644)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// Phrases of the notices served instead of files taken down by the site. Single words such as
+// "copyright" or "removed" also appear in the footers and on ordinary error pages, so only
+// the sentences of a takedown count.
+var removalPattern = regexp.MustCompile(`(?i)(?:removed|taken down|disabled) (?:due to|because of|following|in response to) (?:a |an )?(?:dmca|copyright (?:claim|complaint|notice|infringement))|dmca takedown (?:notice|request)`)
+
+// Reports whether a 403 response says the file was taken down, the read part of the body is put back
+func removalNotice(res *http.Response) bool {
+	if res.StatusCode != http.StatusForbidden || res.Body == nil {
+		return false
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(res.Body, snippetReadLimit))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), res.Body), res.Body}
+
+	return removalPattern.MatchString(bodyText(data))
+}
+
+// Returns the URLs of the files recorded as removed from the site in the creator's manifest
+func removedFiles(dir string) (map[string]manifestEntry, error) {
+	_, removed, err := manifestStatuses(dir)
+	return removed, err
+}
+
+// Returns the last status recorded for every file of the creator's manifest and the files
+// recorded as removed from the site, by URL, reading the manifest once
+func manifestStatuses(dir string) (map[string]string, map[string]manifestEntry, error) {
+	statuses := map[string]string{}
+	removed := map[string]manifestEntry{}
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return statuses, removed, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	// A file downloaded later, such as from another mirror, is no longer removed
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry manifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.URL == "" {
+			continue
+		}
+		statuses[entry.URL] = entry.Status
+		switch entry.Status {
+		case statusRemoved:
+			removed[entry.URL] = entry
+		case statusDownloaded, statusAdopted:
+			delete(removed, entry.URL)
+		}
+	}
+
+	return statuses, removed, scanner.Err()
+}
+
+// Prints the files removed from the site of every creator archived in the directory
+func listRemoved(base string) error {
+	creators, err := archivedCreators(base)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range creators {
+		removed, err := removedFiles(c.Directory)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Directory, err)
+		}
+		if len(removed) == 0 {
+			continue
+		}
+
+		entries := make([]manifestEntry, 0, len(removed))
+		for _, entry := range removed {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Path < entries[j].Path
+		})
+
+		fmt.Printf("%s (%s): %d files removed\n", c.Name, c.URL, len(entries))
+		for _, entry := range entries {
+			fmt.Printf("  %s  %s\n", entry.Path, entry.PostURL)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemovalNotice(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		removed bool
+	}{
+		{http.StatusForbidden, "<h1>This file was removed due to a DMCA takedown request.</h1>", true},
+		{http.StatusForbidden, "<p>Content <b>taken down</b> in response to a copyright claim</p>", true},
+		{http.StatusForbidden, "Received a DMCA takedown notice for this file", true},
+		// Single words of footers and error pages are not a takedown
+		{http.StatusForbidden, "<h1>Forbidden</h1><footer>Copyright 2024 Kemono</footer>", false},
+		{http.StatusForbidden, "<p>The link was removed or expired, try again</p>", false},
+		{http.StatusForbidden, "<p>Access denied. See our DMCA policy.</p>", false},
+		// Only forbidden responses carry takedown notices
+		{http.StatusNotFound, "This file was removed due to a DMCA takedown request.", false},
+		{http.StatusInternalServerError, "removed due to a copyright claim", false},
+	}
+
+	for _, test := range tests {
+		res := &http.Response{StatusCode: test.status, Body: io.NopCloser(strings.NewReader(test.body))}
+		if got := removalNotice(res); got != test.removed {
+			t.Errorf("removalNotice(%d, %q) = %t, want %t", test.status, test.body, got, test.removed)
+		}
+
+		// The body is still readable in full
+		if body, _ := io.ReadAll(res.Body); string(body) != test.body {
+			t.Errorf("removalNotice left the body %q, want %q", body, test.body)
+		}
+	}
+}
+
+func TestRemovalNoticeFixture(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "removed_dmca.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(bytes.NewReader(page))}
+	if !removalNotice(res) {
+		t.Error("the takedown page is not recognized as a takedown notice")
+	}
+}
+
+func TestRemovedFilesSkipped(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst}
+	page, err := os.ReadFile(filepath.Join("testdata", "removed_dmca.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := mockFile(mockPostID(0)+"-zip", "archive.zip")
+	path, _, _ := strings.Cut(link, "?")
+	site.fail(path, mockResponse{status: http.StatusForbidden, body: string(page)})
+	stats, err := downloadCreator(context.Background(), site.profile(), wd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.removed != 1 || stats.files != 2 {
+		t.Fatalf("first run: %d removed, %d files, want 1 and 2", stats.removed, stats.files)
+	}
+
+	// The next run skips the file without asking for it
+	requests := site.count(path)
+	if stats, err = downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if stats.removed != 1 || stats.files != 0 {
+		t.Fatalf("second run: %d removed, %d files, want 1 and 0", stats.removed, stats.files)
+	}
+	if site.count(path) != requests {
+		t.Error("a removed file was requested again")
+	}
+}
+
+func TestRemovedFilesRechecked(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	wd := t.TempDir()
+	cfg := &config{order: orderNewestFirst}
+
+	link := mockFile(mockPostID(0)+"-zip", "archive.zip")
+	path, _, _ := strings.Cut(link, "?")
+	site.fail(path, mockResponse{status: http.StatusForbidden, body: "<p>This file was removed due to a DMCA takedown request.</p>"})
+	stats, err := downloadCreator(context.Background(), site.profile(), wd, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.removed != 1 || stats.files != 2 {
+		t.Fatalf("first run: %d removed, %d files, want 1 and 2", stats.removed, stats.files)
+	}
+
+	// With --recheck-removed the next run asks for the file again and gets it once it is restored
+	cfg.recheckRemoved = true
+	if stats, err = downloadCreator(context.Background(), site.profile(), wd, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if stats.removed != 0 || stats.files != 1 {
+		t.Fatalf("second run: %d removed, %d files, want 0 and 1", stats.removed, stats.files)
+	}
+	dir := filepath.Join(wd, "kemono", "patreon", "Bob [1]")
+	if _, err := os.Stat(filepath.Join(dir, "Bob_"+mockPostID(0)+"_01_archive.zip")); err != nil {
+		t.Error(err)
+	}
+	removed, err := removedFiles(dir)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("removedFiles = %v, %v, want none", removed, err)
+	}
+}
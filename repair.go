@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status of the manifest entries of files found missing or damaged by a repair
+const statusMissing = "missing"
+
+// A file of a repair plan
+type repairFile struct {
+	Path    string `json:"path"`
+	URL     string `json:"url,omitempty"`
+	PostID  string `json:"post_id,omitempty"`
+	PostURL string `json:"post_url,omitempty"`
+	Reason  string `json:"reason"`
+	// Size expected from the manifest, or the size of a leftover file
+	Size int64 `json:"size"`
+}
+
+// Changes bringing a creator directory back in line with its manifest, written by plan-repair
+// for review and carried out by repair
+type repairPlan struct {
+	Directory string    `json:"directory"`
+	Created   time.Time `json:"created"`
+	// Files removed so the next run downloads them again, with their manifest entries marked missing
+	Redownload      []repairFile `json:"redownload"`
+	RedownloadBytes int64        `json:"redownload_bytes"`
+	// Leftovers of unfinished writes
+	Delete      []repairFile `json:"delete"`
+	DeleteBytes int64        `json:"delete_bytes"`
+}
+
+// Compares the files of a creator directory with its manifest and plans the repair
+func planRepair(dir string) (*repairPlan, error) {
+	plan := &repairPlan{Directory: dir, Created: time.Now().UTC(), Redownload: []repairFile{}, Delete: []repairFile{}}
+
+	// The latest download of every file recorded in the manifest
+	downloads := map[string]manifestEntry{}
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry manifestEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Path == "" {
+				continue
+			}
+			switch entry.Status {
+			case statusDownloaded, statusAdopted, statusStub:
+				downloads[entry.Path] = entry
+			case statusMissing:
+				delete(downloads, entry.Path)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, entry := range downloads {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path)))
+		reason := ""
+		switch {
+		case os.IsNotExist(err):
+			reason = "missing"
+		case err != nil:
+			return nil, err
+		case info.Size() == 0 && entry.Size > 0:
+			reason = "empty"
+		case entry.Size > 0 && info.Size() != entry.Size:
+			reason = fmt.Sprintf("size %d differs from the downloaded %d", info.Size(), entry.Size)
+		default:
+			continue
+		}
+		plan.Redownload = append(plan.Redownload, repairFile{Path: path, URL: entry.URL, PostID: entry.PostID, PostURL: entry.PostURL, Reason: reason, Size: entry.Size})
+		plan.RedownloadBytes += entry.Size
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasSuffix(e.Name(), ".partial"):
+			plan.Delete = append(plan.Delete, repairFile{Path: e.Name(), Reason: "unfinished write", Size: info.Size()})
+			plan.DeleteBytes += info.Size()
+		case info.Size() == 0:
+			// Empty files the manifest does not know, such as with --no-manifest
+			if _, known := downloads[e.Name()]; !known {
+				plan.Redownload = append(plan.Redownload, repairFile{Path: e.Name(), Reason: "empty"})
+			}
+		}
+	}
+
+	sort.Slice(plan.Redownload, func(i, j int) bool { return plan.Redownload[i].Path < plan.Redownload[j].Path })
+	sort.Slice(plan.Delete, func(i, j int) bool { return plan.Delete[i].Path < plan.Delete[j].Path })
+	return plan, nil
+}
+
+// Writes the repair plan of a creator directory for review, without changing anything
+func planRepairCommand(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: kemono-dl plan-repair DIR [PLAN]")
+	}
+
+	plan, err := planRepair(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%d files (%s) to download again, %d leftover files (%s) to delete", len(plan.Redownload), formatSize(plan.RedownloadBytes), len(plan.Delete), formatSize(plan.DeleteBytes))
+	if len(args) == 1 {
+		fmt.Println(string(data))
+		return nil
+	}
+	return writeFileAtomic(args[1], data, 0644)
+}
+
+// Carries out a reviewed repair plan. The files to download again are removed and marked missing
+// in the manifest, the next run of the creator downloads them.
+func repairCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: kemono-dl repair PLAN")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var plan repairPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return fmt.Errorf("%s is not a repair plan: %w", args[0], err)
+	}
+	dir := plan.Directory
+
+	for _, file := range plan.Delete {
+		if !strings.HasSuffix(file.Path, ".partial") {
+			return fmt.Errorf("refusing to delete %s, only unfinished writes are deleted", file.Path)
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(file.Path))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	m, err := openManifest(dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	for _, file := range plan.Redownload {
+		path := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		m.record(post{id: file.PostID, url: file.PostURL}, file.URL, path, statusMissing, errors.New(file.Reason))
+	}
+
+	log.Printf("Deleted %d leftover files and removed %d files, run kemono-dl on the creator to download them again", len(plan.Delete), len(plan.Redownload))
+	return nil
+}
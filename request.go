@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sends a single HTTP request
+type sendFunc func(req *http.Request) (*http.Response, error)
+
+// Wraps the sending of a request with additional behaviour
+type middleware func(next sendFunc) sendFunc
+
+// HTTP client sending every request through a chain of middleware
+type client struct {
+	send sendFunc
+}
+
+// Builds a client from the transport client and the middleware, the first middleware being the outermost
+func newClient(transport *http.Client, chain ...middleware) *client {
+	send := transport.Do
+	for i := len(chain) - 1; i >= 0; i-- {
+		send = chain[i](send)
+	}
+	return &client{send: send}
+}
+
+// Sends the request, implements the interface expected by grab
+func (c *client) Do(req *http.Request) (*http.Response, error) {
+	return c.send(req)
+}
+
+// Limits the number of requests sent in a time interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// Blocks until the next request is allowed to be sent
+func (r *rateLimiter) wait(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Time since the last request is measured on the monotonic clock, a wait beyond the interval
+	// can only come from a clock change and is cut down to the interval
+	wait := r.interval - time.Since(r.last)
+	if wait > r.interval {
+		wait = r.interval
+	}
+	if wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+	r.last = time.Now()
+
+	return nil
+}
+
+// Limiter shared by every request of the run
+var limiter = &rateLimiter{}
+
+// Default number of retries of a failed request, the delay before the first one,
+// the factor it grows by after every attempt and the longest delay
+const (
+	maxRetries      = 3
+	retryBackoff    = time.Second
+	retryMultiplier = 2
+	maxRetryWait    = 5 * time.Minute
+)
+
+// Number of retries of a failed request and the delays between them
+type retryPolicy struct {
+	maxRetries int
+	backoff    time.Duration
+	multiplier float64
+	maxWait    time.Duration
+}
+
+// Retry policy of every request of the run
+var retryConfig = &retryPolicy{maxRetries: maxRetries, backoff: retryBackoff, multiplier: retryMultiplier, maxWait: maxRetryWait}
+
+// Checks the policy set by the options
+func (p *retryPolicy) check() error {
+	switch {
+	case p.maxRetries < 0:
+		return errors.New("--max-retries cannot be negative")
+	case p.backoff <= 0:
+		return errors.New("--retry-backoff must be positive")
+	case p.multiplier < 1:
+		return errors.New("--retry-multiplier cannot be less than 1")
+	case p.maxWait < p.backoff:
+		return errors.New("--max-retry-wait cannot be shorter than --retry-backoff")
+	}
+	return nil
+}
+
+// Returns the delay before the retry following the one that waited for the delay
+func (p *retryPolicy) next(delay time.Duration) time.Duration {
+	next := time.Duration(float64(delay) * p.multiplier)
+	if next > p.maxWait || next < 0 {
+		return p.maxWait
+	}
+	return next
+}
+
+// Default size of the retry pool and the time it takes to earn back a retry
+const (
+	retryPoolSize   = 100
+	retryPoolRefill = 6 * time.Second
+)
+
+// Retries shared by every request of the run, so a dead site fails fast instead of
+// retrying every request. The pool earns back a retry every refill interval.
+type retryBudget struct {
+	mu sync.Mutex
+	// Size of the pool, 0 for no limit
+	size   int
+	tokens float64
+	refill time.Duration
+	last   time.Time
+
+	// Retries taken from the pool and the ones refused while it was empty
+	used    int
+	refused int
+}
+
+// Retry budget shared by every request of the run
+var retries = &retryBudget{size: retryPoolSize, tokens: retryPoolSize, refill: retryPoolRefill}
+
+// Takes a retry from the pool, reports false when the pool is empty
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size > 0 {
+		now := time.Now()
+		if !b.last.IsZero() && b.refill > 0 {
+			b.tokens += float64(now.Sub(b.last)) / float64(b.refill)
+		}
+		if b.tokens > float64(b.size) {
+			b.tokens = float64(b.size)
+		}
+		b.last = now
+
+		if b.tokens < 1 {
+			b.refused++
+			return false
+		}
+		b.tokens--
+	}
+	b.used++
+	return true
+}
+
+// Sets the size of the pool, filling the pool
+func (b *retryBudget) resize(size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size, b.tokens = size, float64(size)
+}
+
+// Returns the number of retries taken and refused so far
+func (b *retryBudget) counts() (used int, refused int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used, b.refused
+}
+
+// Maximum number of connections kept open to a single host, covers the post workers and the downloads
+const maxConnsPerHost = 16
+
+// Transport shared by all requests of the run, keeps the connections to the site
+// and its data hosts open between files so small files skip the TCP and TLS handshakes
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   maxConnsPerHost,
+	MaxConnsPerHost:       maxConnsPerHost,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// Client used for all requests of the run
+var httpClient = newClient(&http.Client{Transport: transport},
+	setHeaders,
+	logRequests,
+	retryRequests(retryConfig, retries),
+	scheduleRequests,
+	rateLimit(limiter),
+	countRequests,
+	accountTransfers,
+	checkClock,
+	injectFaults,
+)
+
+// Sends a request through the shared client and classifies unsuccessful responses
+func doRequest(ctx context.Context, method string, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(res); err != nil {
+		var resErr *ResponseError
+		if errors.As(err, &resErr) {
+			body, _ := io.ReadAll(io.LimitReader(res.Body, int64(bodyReadLimit())))
+			describeBody(resErr, body)
+		}
+		res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Logs requests that fail without a response
+func logRequests(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := next(req)
+		if err != nil && req.Context().Err() == nil {
+			log.Printf("Request %s %s failed: %s", req.Method, req.URL, err)
+		}
+		return res, err
+	}
+}
+
+// Retries requests failing with a transient error, waiting longer after every attempt.
+// The retries are taken from the budget, requests fail without retrying while it is empty.
+func retryRequests(policy *retryPolicy, budget *retryBudget) middleware {
+	return func(next sendFunc) sendFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			wait := policy.backoff
+			for attempt := 0; ; attempt++ {
+				res, err := next(req)
+
+				// Unsuccessful responses are classified but still returned as responses
+				failure := err
+				if err == nil {
+					failure = checkResponse(res)
+				}
+				if attempt >= policy.maxRetries || !retryable(failure) || !budget.take() {
+					return res, err
+				}
+				if res != nil {
+					res.Body.Close()
+				}
+
+				// Waits at least as long as the server asked for
+				delay := wait
+				var resErr *ResponseError
+				if errors.As(failure, &resErr) && resErr.RetryAfter > delay {
+					delay = resErr.RetryAfter
+				}
+
+				log.Printf("Retrying %s in %s: %s", req.URL, delay, failure)
+				if err := sleep(req.Context(), delay); err != nil {
+					return nil, err
+				}
+				wait = policy.next(wait)
+			}
+		}
+	}
+}
+
+// Reports whether a request failing with the error may succeed when retried
+func retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var resErr *ResponseError
+	if errors.As(err, &resErr) {
+		return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) || errors.Is(err, ErrChallenge)
+	}
+
+	// Errors without a response are network failures
+	return true
+}
+
+// Waits for the rate limiter before sending a request
+func rateLimit(limiter *rateLimiter) middleware {
+	return func(next sendFunc) sendFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if err := limiter.wait(req.Context()); err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+}
+
+// Records the status of every response in the metrics
+func countRequests(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := next(req)
+		if err != nil {
+			runMetrics.request(0)
+		} else {
+			runMetrics.request(res.StatusCode)
+		}
+		return res, err
+	}
+}
+
+// Sleeps for the duration unless the context is cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []mockResponse
+		wantErr  error
+		requests int
+	}{
+		{"success", nil, nil, 1},
+		{"rate limited", []mockResponse{{status: 429, header: http.Header{"Retry-After": {"0"}}}, {status: 429}}, nil, 3},
+		{"server error", []mockResponse{{status: 502}}, nil, 2},
+		{"truncated", []mockResponse{{status: 500, body: "<html>down for maintenance</html>", truncate: true}}, nil, 2},
+		{"not found", []mockResponse{{status: 404}}, ErrNotFound, 1},
+		{"forbidden", []mockResponse{{status: 403}}, ErrStatus, 1},
+		{"gives up", []mockResponse{{status: 503}, {status: 503}, {status: 503}, {status: 503}}, ErrServerError, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testRun(t)
+			site := newMockSite(t, 1)
+			site.fail("/patreon/user/1", test.script...)
+
+			_, err := fetchDocument(context.Background(), site.profile().URL())
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if n := site.count("/patreon/user/1"); n != test.requests {
+				t.Errorf("sent %d requests, want %d", n, test.requests)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	testRun(t)
+	retries.resize(1)
+	site := newMockSite(t, 1)
+	site.fail("/patreon/user/1", mockResponse{status: 503}, mockResponse{status: 503}, mockResponse{status: 503})
+
+	_, err := fetchDocument(context.Background(), site.profile().URL())
+	if !errors.Is(err, ErrServerError) {
+		t.Fatalf("err = %v, want ErrServerError", err)
+	}
+	if n := site.count("/patreon/user/1"); n != 2 {
+		t.Errorf("sent %d requests with a pool of one retry, want 2", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	testRun(t)
+	limiter.interval = 20 * time.Millisecond
+	site := newMockSite(t, 2)
+	link := mockFile("rate", "image.jpg")
+	dir := t.TempDir()
+
+	// Pages and files share the limit
+	for i := 0; i < 3; i++ {
+		if _, err := fetchDocument(context.Background(), site.profile().URL()); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(dir, fmt.Sprintf("%d.jpg", i))
+		if _, err := downloadFile(withFileTransfer(context.Background(), site.profile().URL()), site.URL+link, dest, &config{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	if len(site.times) < 6 {
+		t.Fatalf("site saw %d requests, want at least 6", len(site.times))
+	}
+	for i := 1; i < len(site.times); i++ {
+		// The server sees the requests a little later than they were sent
+		if gap := site.times[i].Sub(site.times[i-1]); gap < limiter.interval*3/4 {
+			t.Errorf("request %d (%s) came %s after the previous one, want at least %s", i, site.requests[i], gap, limiter.interval)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	r := &rateLimiter{interval: 50 * time.Millisecond}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := r.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three requests took %s, want at least two intervals", elapsed)
+	}
+
+	// A cancelled wait returns at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled wait = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterClockChange(t *testing.T) {
+	// A last request in the future, as after the clock was set back, waits one interval at most
+	r := &rateLimiter{interval: 20 * time.Millisecond, last: time.Now().Add(time.Hour)}
+
+	start := time.Now()
+	if err := r.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait after a clock change took %s, want at most the interval", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	skew := siteClockSkew()
+	t.Cleanup(func() {
+		clockSkew.mu.Lock()
+		clockSkew.offset = skew
+		clockSkew.mu.Unlock()
+	})
+	setSkew := func(offset time.Duration) {
+		clockSkew.mu.Lock()
+		clockSkew.offset = offset
+		clockSkew.mu.Unlock()
+	}
+	// Date of the site's clock the given time from now
+	siteDate := func(offset time.Duration, after time.Duration) string {
+		return time.Now().Add(offset + after).UTC().Format(http.TimeFormat)
+	}
+
+	tests := []struct {
+		name  string
+		skew  time.Duration
+		value func() string
+		want  time.Duration
+	}{
+		{"missing", 0, func() string { return "" }, 0},
+		{"seconds", 0, func() string { return "5" }, 5 * time.Second},
+		{"negative seconds", 0, func() string { return "-3" }, 0},
+		{"garbage", 0, func() string { return "soon" }, 0},
+		{"beyond the limit", 0, func() string { return "99999" }, maxRetryAfter},
+		{"date", 0, func() string { return siteDate(0, 30*time.Second) }, 30 * time.Second},
+		{"past date", 0, func() string { return siteDate(0, -time.Minute) }, 0},
+		// Dates of a site clock ahead or behind are corrected for the measured difference
+		{"date of a clock ahead", 10 * time.Minute, func() string { return siteDate(10*time.Minute, 30*time.Second) }, 30 * time.Second},
+		{"date of a clock behind", -2 * time.Hour, func() string { return siteDate(-2*time.Hour, 30*time.Second) }, 30 * time.Second},
+		{"past date of a clock ahead", 10 * time.Minute, func() string { return siteDate(10*time.Minute, -time.Minute) }, 0},
+		{"date far ahead", 0, func() string { return siteDate(0, 48*time.Hour) }, maxRetryAfter},
+	}
+
+	for _, test := range tests {
+		setSkew(test.skew)
+		got := retryAfter(test.value())
+		// Dates have a resolution of a second
+		if diff := got - test.want; diff < -time.Second || diff > time.Second {
+			t.Errorf("%s: retryAfter = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDownloadsReuseConnections(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	var conns, reused int
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		conns++
+		if info.Reused {
+			reused++
+		}
+	}}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+
+	for i := 0; i < 5; i++ {
+		link := mockFile(fmt.Sprintf("small-%d", i), "image.jpg")
+		dest := filepath.Join(dir, fmt.Sprintf("%d.jpg", i))
+		if _, err := downloadFile(ctx, site.URL+link, dest, &config{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns < 5 {
+		t.Fatalf("traced %d connections for 5 downloads", conns)
+	}
+	// Only the first request may open a connection
+	if reused != conns-1 {
+		t.Errorf("%d of %d requests reused a connection, want %d", reused, conns, conns-1)
+	}
+}
+
+func TestCompressedPages(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 3)
+	link := mockFile("identity", "video.mp4")
+
+	total, err := numberOfPosts(context.Background(), site.profile().URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("numberOfPosts of the compressed page = %d, want 3", total)
+	}
+	dest := filepath.Join(t.TempDir(), "video.mp4")
+	if _, err := downloadFile(withFileTransfer(context.Background(), site.profile().URL()), site.URL+link, dest, &config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	for i, request := range site.requests {
+		encoding := site.headers[i].Get("Accept-Encoding")
+		if strings.HasPrefix(request, "/data/") {
+			if encoding != "identity" {
+				t.Errorf("file request %s accepted %q, want identity", request, encoding)
+			}
+		} else if !strings.Contains(encoding, "gzip") {
+			t.Errorf("page request %s accepted %q, want gzip", request, encoding)
+		}
+	}
+}
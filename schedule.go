@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Daily window in which files are downloaded, set by --active-hours
+type activeHours struct {
+	mu sync.Mutex
+	// Times of day the window opens and closes, the window spans midnight when end is before start
+	start time.Duration
+	end   time.Duration
+	// Lets the pages be fetched outside the window, set by --metadata-anytime
+	metadataAnytime bool
+	// Time the parked requests wait for, logged once per closed window
+	opens time.Time
+}
+
+// Active hours of the run, nil unless --active-hours is set
+var schedule *activeHours
+
+// Parses a time of day in the 15:04 format into the time since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Parses the --active-hours value, e.g. "02:00-08:00"
+func parseActiveHours(value string) (*activeHours, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid active hours %q, expected e.g. 02:00-08:00", value)
+	}
+
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid active hours %q, the window is empty", value)
+	}
+
+	return &activeHours{start: start, end: end}, nil
+}
+
+// Returns how long until the window opens, zero inside the window
+func (a *activeHours) untilOpen(now time.Time) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := now.Sub(midnight)
+
+	inside := day >= a.start && day < a.end
+	if a.end < a.start {
+		inside = day >= a.start || day < a.end
+	}
+	if inside {
+		return 0
+	}
+
+	open := midnight.Add(a.start)
+	if !open.After(now) {
+		open = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Add(a.start)
+	}
+	return open.Sub(now)
+}
+
+// Formats the window for the log
+func (a *activeHours) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(a.start) + "-" + format(a.end)
+}
+
+// Holds back the requests outside the active hours, the pages are let through with --metadata-anytime.
+// Downloads already running are not interrupted when the window closes.
+func scheduleRequests(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if schedule == nil {
+			return next(req)
+		}
+		if _, file := fileTransfer(req); !file && schedule.metadataAnytime {
+			return next(req)
+		}
+
+		// Only the first parked request logs the schedule, the lock is not held while waiting
+		// so the others can park behind it and a cancelled request returns right away
+		now := time.Now()
+		schedule.mu.Lock()
+		wait := schedule.untilOpen(now)
+		opens := now.Add(wait).Truncate(time.Minute)
+		first := wait > 0 && !opens.Equal(schedule.opens)
+		if first {
+			schedule.opens = opens
+		}
+		schedule.mu.Unlock()
+
+		if first {
+			log.Printf("Outside the active hours %s, waiting %s until %s", schedule, wait.Round(time.Minute), opens.Format("Jan 2 15:04"))
+		}
+		if wait > 0 {
+			if err := sleep(req.Context(), wait); err != nil {
+				return nil, err
+			}
+		}
+
+		return next(req)
+	}
+}
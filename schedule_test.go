@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScheduleCancelWhileParked(t *testing.T) {
+	// The window opens in two hours, so every request is parked
+	now := time.Now()
+	day := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	start := (day + 2*time.Hour).Truncate(time.Minute) % (24 * time.Hour)
+	schedule = &activeHours{start: start, end: (start + time.Hour) % (24 * time.Hour)}
+	t.Cleanup(func() { schedule = nil })
+
+	send := scheduleRequests(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("request to %s was let through outside the active hours", req.URL)
+		return nil, errors.New("unexpected request")
+	})
+	request := func(ctx context.Context) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/", nil)
+			_, err := send(req)
+			done <- err
+		}()
+		return done
+	}
+
+	parkedCtx, stopParked := context.WithCancel(context.Background())
+	parked := request(parkedCtx)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := request(ctx)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled request returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled request stayed parked behind the other one")
+	}
+
+	stopParked()
+	if err := <-parked; !errors.Is(err, context.Canceled) {
+		t.Errorf("parked request returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A creator listed on the start page of the archive browser
+type servedCreator struct {
+	Name  string
+	Site  string
+	Link  template.URL
+	Posts int
+}
+
+var serveIndexTemplate = template.Must(template.New("serve").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>kemono-dl archive</title>` + galleryStyle + `</head>
+<body>
+<h1>kemono-dl archive</h1>
+<div class="grid">
+{{range .}}<div class="post"><a href="{{.Link}}">{{.Name}}</a><br>{{.Site}}, {{.Posts}} posts</div>
+{{else}}<p>No creators downloaded yet</p>
+{{end}}</div>
+</body></html>
+`))
+
+// Returns the creators downloaded into the creator directories of the base directory
+func servedCreators(base string) ([]servedCreator, error) {
+	dirs, err := creatorDirectories(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var creators []servedCreator
+	for _, rel := range dirs {
+		dir := filepath.Join(base, rel)
+		local, err := localPosts(dir, directoryPrefix(dir))
+		if err != nil {
+			return nil, err
+		}
+
+		// Links to the gallery when it was generated, otherwise to the file listing
+		link := "/"
+		for _, segment := range strings.Split(filepath.ToSlash(rel), "/") {
+			link += url.PathEscape(segment) + "/"
+		}
+		if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+			link += "index.html"
+		}
+
+		creators = append(creators, servedCreator{Name: filepath.Base(rel), Site: filepath.ToSlash(filepath.Dir(rel)), Link: template.URL(link), Posts: len(local)})
+	}
+
+	sort.Slice(creators, func(i, j int) bool {
+		return creators[i].Name < creators[j].Name
+	})
+	return creators, nil
+}
+
+// Serves a file of the archive, encrypted files are decrypted on the fly without range request support
+func serveFile(w http.ResponseWriter, r *http.Request, base string, files http.Handler) {
+	file := filepath.Join(base, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if _, err := os.Stat(file); err == nil || !os.IsNotExist(err) {
+		files.ServeHTTP(w, r)
+		return
+	}
+
+	encrypted, err := os.Open(file + encryptedSuffix)
+	if err != nil {
+		files.ServeHTTP(w, r)
+		return
+	}
+	defer encrypted.Close()
+
+	if encryptionKey == nil {
+		http.Error(w, "This archive is encrypted, start kemono-dl serve with the --encrypt-key it was encrypted with", http.StatusForbidden)
+		return
+	}
+	plain, err := decryptReader(encrypted)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(file)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if _, err := io.Copy(w, plain); err != nil {
+		log.Printf("Failed to serve %s: %s", r.URL.Path, err)
+	}
+}
+
+// Files of the archive served by the archive browser. The dotfiles, such as the page cache,
+// the debug bodies, the unfinished downloads and the usage file, and the encryption key
+// are neither served nor listed.
+type archiveFS struct {
+	http.FileSystem
+	// Cleaned paths of the other files kept out
+	hidden map[string]bool
+}
+
+// Reports whether the cleaned path of a request is kept out of the archive browser
+func (a archiveFS) hides(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return a.hidden[name]
+}
+
+func (a archiveFS) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if a.hides(name) {
+		return nil, fs.ErrNotExist
+	}
+	file, err := a.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return archiveFile{File: file, fs: a, dir: name}, nil
+}
+
+// File of the archive listing only the entries the archive browser serves
+type archiveFile struct {
+	http.File
+	fs  archiveFS
+	dir string
+}
+
+func (f archiveFile) Readdir(count int) ([]fs.FileInfo, error) {
+	entries, err := f.File.Readdir(count)
+	visible := entries[:0]
+	for _, entry := range entries {
+		if !f.fs.hides(path.Join(f.dir, entry.Name())) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible, err
+}
+
+// Returns the handler of the archive browser over the base directory, the key file is kept out of it
+func serveHandler(base string, keyFile string) http.Handler {
+	archive := archiveFS{FileSystem: http.Dir(base), hidden: map[string]bool{}}
+	if keyFile != "" {
+		absBase, errBase := filepath.Abs(base)
+		absKey, errKey := filepath.Abs(keyFile)
+		if rel, err := filepath.Rel(absBase, absKey); errBase == nil && errKey == nil && err == nil && !strings.HasPrefix(rel, "..") {
+			archive.hidden[path.Clean("/"+filepath.ToSlash(rel))] = true
+		}
+	}
+
+	files := http.FileServer(archive)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if archive.hides(path.Clean("/" + r.URL.Path)) {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/" {
+			serveFile(w, r, base, files)
+			return
+		}
+
+		creators, err := servedCreators(base)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := serveIndexTemplate.Execute(w, creators); err != nil {
+			log.Printf("Failed to render the creator list: %s", err)
+		}
+	})
+
+	return mux
+}
+
+// Serves a read-only browser over the downloaded creators, the files are served
+// with range request support so videos can be seeked
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "Address to serve the archive on, e.g. :8080 to serve it to the network")
+	keyFile := flags.String("encrypt-key", "", "Key the files of the archive were encrypted with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return errors.New("usage: kemono-dl serve [--addr ADDR] [--encrypt-key FILE] [DIR]")
+	}
+
+	base := flags.Arg(0)
+	if base == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		base = wd
+	}
+
+	// Decrypts the files with the key, which is never generated here
+	if *keyFile == "" {
+		*keyFile = encryptionKeyFile
+	} else {
+		if _, err := os.Stat(*keyFile); err != nil {
+			return err
+		}
+		var err error
+		if encryptionKey, err = loadEncryptionKey(*keyFile); err != nil {
+			return fmt.Errorf("failed to load the encryption key: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: serveHandler(base, *keyFile)}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("Serving %s on %s", base, *addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
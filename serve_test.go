@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeHidesDotfiles(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "kemono", "patreon", "Bob [1]")
+	for name, content := range map[string]string{
+		"1000 image.jpg":          "image",
+		".cache/page.html":        "cached page",
+		".partial/1000 image.jpg": "half an image",
+		".kemono-dl-usage.json":   "{}",
+	} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := filepath.Join(base, "archive.key")
+	if err := os.WriteFile(key, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := serveHandler(base, key)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/kemono/patreon/Bob%20%5B1%5D/1000%20image.jpg", http.StatusOK},
+		{"/kemono/patreon/Bob%20%5B1%5D/.cache/page.html", http.StatusNotFound},
+		{"/kemono/patreon/Bob%20%5B1%5D/.partial/1000%20image.jpg", http.StatusNotFound},
+		{"/kemono/patreon/Bob%20%5B1%5D/.partial/", http.StatusNotFound},
+		{"/kemono/patreon/Bob%20%5B1%5D/.kemono-dl-usage.json", http.StatusNotFound},
+		{"/archive.key", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if recorder.Code != test.status {
+			t.Errorf("GET %s = %d, want %d", test.path, recorder.Code, test.status)
+		}
+	}
+
+	// The directory listings leave the hidden files out
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/kemono/patreon/Bob%20%5B1%5D/", nil))
+	listing := recorder.Body.String()
+	if !strings.Contains(listing, "1000 image.jpg") {
+		t.Errorf("listing %q is missing the image", listing)
+	}
+	for _, hidden := range []string{".cache", ".partial", ".kemono-dl-usage.json"} {
+		if strings.Contains(listing, hidden) {
+			t.Errorf("listing %q shows %s", listing, hidden)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(recorder.Body.String(), "archive.key") {
+		t.Error("the start page shows the key file")
+	}
+}
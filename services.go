@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Behaviour of a service mirrored by one of the sites
+type serviceQuirks struct {
+	// Site mirroring the service, kemono or coomer
+	site string
+	// Pattern the creator IDs of the service match
+	userPattern *regexp.Regexp
+	// Describes how the posts of the service look on the site
+	notes string
+	// New archives of the service keep the file names of the site without the position prefix,
+	// for services whose file names are meaningful
+	plainNames bool
+}
+
+// Patterns shared by several services
+var (
+	numericUser = regexp.MustCompile(`^\d+$`)
+	nameUser    = regexp.MustCompile(`^[\w.\-]+$`)
+)
+
+// Known services, a new service only needs an entry here
+var services = map[string]serviceQuirks{
+	"patreon":       {site: "kemono", userPattern: numericUser, notes: "attachments and inline images"},
+	"fanbox":        {site: "kemono", userPattern: numericUser, notes: "mostly inline images", plainNames: true},
+	"fantia":        {site: "kemono", userPattern: numericUser, notes: "attachments and inline images"},
+	"gumroad":       {site: "kemono", userPattern: nameUser, notes: "a single main file per product", plainNames: true},
+	"subscribestar": {site: "kemono", userPattern: nameUser, notes: "user names instead of numeric IDs"},
+	"dlsite":        {site: "kemono", userPattern: regexp.MustCompile(`^RG\d+$`), notes: "works packaged in zip archives", plainNames: true},
+	"discord":       {site: "kemono", userPattern: numericUser, notes: "server IDs, channels listed as posts"},
+	"boosty":        {site: "kemono", userPattern: nameUser, notes: "user names instead of numeric IDs"},
+	"afdian":        {site: "kemono", userPattern: nameUser, notes: "attachments and inline images"},
+	"onlyfans":      {site: "coomer", userPattern: nameUser, notes: "user names, mostly videos"},
+	"fansly":        {site: "coomer", userPattern: numericUser, notes: "numeric IDs, mostly videos"},
+	"candfans":      {site: "coomer", userPattern: numericUser, notes: "numeric IDs, mostly videos"},
+}
+
+// Patterns the user IDs of services missing from the table must match on each site
+var userPatterns = map[string]*regexp.Regexp{
+	"kemono": numericUser,
+	"coomer": nameUser,
+}
+
+// Checks the service and the user ID of a creator on a site
+func checkService(site string, service string, user string) error {
+	quirks, ok := services[service]
+	if !ok {
+		if !userPatterns[site].MatchString(user) {
+			return fmt.Errorf("invalid user id %q", user)
+		}
+		return nil
+	}
+
+	if quirks.site != site {
+		return fmt.Errorf("service %s is mirrored by %s, not %s", service, quirks.site, site)
+	}
+	if !quirks.userPattern.MatchString(user) {
+		return fmt.Errorf("invalid %s user id %q", service, user)
+	}
+	return nil
+}
+
+// Prints the known services with their quirks
+func listServices() {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := services[names[i]], services[names[j]]
+		if a.site != b.site {
+			return a.site > b.site
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		quirks := services[name]
+		naming := "indexed"
+		if quirks.plainNames {
+			naming = "plain"
+		}
+		fmt.Printf("%-14s %-7s %-16s %-8s %s\n", name, quirks.site, quirks.userPattern, naming, quirks.notes)
+	}
+}
+
+// Returns the naming scheme of the files of a creator, logged at the start of the run
+func namingScheme(indexed bool) string {
+	if indexed {
+		return "{name}_{post}_{position}_{file}"
+	}
+	return "{name}_{post}_{file}"
+}
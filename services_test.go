@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestCheckService(t *testing.T) {
+	tests := []struct {
+		site    string
+		service string
+		user    string
+		valid   bool
+	}{
+		{"kemono", "patreon", "12345", true},
+		{"kemono", "patreon", "bob", false},
+		{"kemono", "fanbox", "67890", true},
+		{"kemono", "gumroad", "bob.shop", true},
+		{"kemono", "subscribestar", "bob-art", true},
+		{"kemono", "dlsite", "RG12345", true},
+		{"kemono", "dlsite", "12345", false},
+		{"kemono", "discord", "112233", true},
+		{"coomer", "onlyfans", "bob_99", true},
+		{"coomer", "onlyfans", "bob/99", false},
+		{"coomer", "fansly", "123456789", true},
+		{"coomer", "fansly", "bob", false},
+		// Services are only valid on the site mirroring them
+		{"coomer", "patreon", "12345", false},
+		{"kemono", "onlyfans", "bob", false},
+		// Services missing from the table fall back to the pattern of the site
+		{"kemono", "newservice", "12345", true},
+		{"kemono", "newservice", "bob", false},
+		{"coomer", "newservice", "bob", true},
+	}
+
+	for _, test := range tests {
+		err := checkService(test.site, test.service, test.user)
+		if (err == nil) != test.valid {
+			t.Errorf("checkService(%q, %q, %q) = %v, want valid %t", test.site, test.service, test.user, err, test.valid)
+		}
+	}
+}
+
+func TestServicesTable(t *testing.T) {
+	for name, quirks := range services {
+		if quirks.site != "kemono" && quirks.site != "coomer" {
+			t.Errorf("service %s is mirrored by unknown site %q", name, quirks.site)
+		}
+		if quirks.userPattern == nil {
+			t.Errorf("service %s has no user pattern", name)
+		}
+		if quirks.notes == "" {
+			t.Errorf("service %s has no notes", name)
+		}
+	}
+}
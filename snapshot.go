@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Names of the files in the creator's directory, listed once when the download starts
+// so existing files are found without a stat call each, which is slow on network shares.
+// A nil snapshot checks every file on disk, which is used with --no-snapshot.
+type dirSnapshot struct {
+	mu        sync.Mutex
+	directory string
+	names     map[string]bool
+}
+
+// Lists the files in the directory, a missing directory gives an empty snapshot
+func takeSnapshot(directory string) (*dirSnapshot, error) {
+	s := &dirSnapshot{directory: filepath.Clean(directory), names: map[string]bool{}}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			s.names[entry.Name()] = true
+		}
+	}
+
+	return s, nil
+}
+
+// Reports whether the file exists
+func (s *dirSnapshot) exists(file string) bool {
+	if s == nil || filepath.Dir(file) != s.directory {
+		_, err := os.Stat(file)
+		return err == nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names[filepath.Base(file)]
+}
+
+// Reports whether the file exists and is not an unfinished download of the external downloader
+func (s *dirSnapshot) complete(file string) bool {
+	return s.exists(file) && !s.exists(file+".aria2")
+}
+
+// Records a file written into the directory
+func (s *dirSnapshot) add(file string) {
+	if s == nil || filepath.Dir(file) != s.directory {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[filepath.Base(file)] = true
+	delete(s.names, filepath.Base(file)+".aria2")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Writes the file through a temporary file renamed over it once flushed to the disk,
+// so a crash mid-write leaves either the old or the new content. The temporary file is
+// created with a unique name next to the file, concurrent writers never share it.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	// Keeps the .partial suffix so plan-repair finds the files left by a crash
+	out, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.partial")
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(data)
+	if err == nil {
+		err = out.Chmod(perm)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), file)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// Number of previous versions of a changed metadata file kept with a timestamp suffix, set by --keep-metadata-history
+var metadataHistory int
+
+// Timestamp suffix of the kept versions of the metadata files, in nanoseconds so versions
+// kept within the same second do not replace each other
+const metadataVersionFormat = "20060102-150405.000000000"
+
+// Suffix of the kept versions of the metadata files, the versions kept before the nanoseconds included
+var metadataVersionPattern = regexp.MustCompile(`^\d{8}-\d{6}(\.\d{9})?$`)
+
+// Metadata files rewritten with new content and left untouched as unchanged during the run
+var metadataWrites struct {
+	modified  atomic.Int64
+	untouched atomic.Int64
+}
+
+// Writes a metadata file of a creator directory unless it already has the content, so
+// unchanged archives are not touched by backups and syncs. Reports whether the file was written.
+func writeMetadata(file string, data []byte) (bool, error) {
+	previous, err := os.ReadFile(file)
+	if err == nil && bytes.Equal(previous, data) {
+		metadataWrites.untouched.Add(1)
+		return false, nil
+	}
+
+	// Keeps the replaced versions with --keep-metadata-history
+	if err == nil && metadataHistory > 0 {
+		if err := keepMetadataVersion(file, previous); err != nil {
+			log.Printf("Failed to keep the previous version of %s: %s", filepath.Base(file), err)
+		}
+	}
+
+	if err := writeFileAtomic(file, data, 0644); err != nil {
+		return false, err
+	}
+	metadataWrites.modified.Add(1)
+	return true, nil
+}
+
+// Saves the previous content of a metadata file with a timestamp suffix, dropping the oldest versions beyond the limit
+func keepMetadataVersion(file string, previous []byte) error {
+	// Clocks of a coarser resolution give the same timestamp twice, the later version is kept
+	// a nanosecond after the earlier one
+	stamp := time.Now()
+	version := file + "." + stamp.Format(metadataVersionFormat)
+	for {
+		_, err := os.Lstat(version)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		stamp = stamp.Add(time.Nanosecond)
+		version = file + "." + stamp.Format(metadataVersionFormat)
+	}
+	if err := writeFileAtomic(version, previous, 0644); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	var versions []string
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), filepath.Base(file)+".")
+		if ok && metadataVersionPattern.MatchString(suffix) {
+			versions = append(versions, entry.Name())
+		}
+	}
+
+	// The timestamps sort in the order the versions were kept
+	sort.Strings(versions)
+	for len(versions) > metadataHistory {
+		if err := os.Remove(filepath.Join(filepath.Dir(file), versions[0])); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// Returns the _schema version stamped on a JSON metadata file, 0 for files from before the versions
+func metadataSchema(data []byte) int {
+	var stamp struct {
+		Schema int `json:"_schema"`
+	}
+	if err := json.Unmarshal(data, &stamp); err != nil {
+		return 0
+	}
+	return stamp.Schema
+}
+
+// Refuses to overwrite a metadata file written by a newer version of kemono-dl,
+// which may have fields this version would drop
+func checkSchema(file string, schema int) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	if metadataSchema(data) > schema {
+		return fmt.Errorf("%s was written by a newer version of kemono-dl, not overwriting it", file)
+	}
+	return nil
+}
+
+// Moves a state file that cannot be parsed aside with the .corrupt suffix, so it is
+// kept for inspection while the state is rebuilt
+func setAsideCorrupt(file string, cause error) {
+	if err := os.Rename(file, file+".corrupt"); err != nil {
+		log.Printf("WARNING: %s is corrupt (%s) and could not be moved aside: %s", file, cause, err)
+		return
+	}
+	log.Printf("WARNING: %s is corrupt (%s), moved it to %s.corrupt and starting over", file, cause, file)
+}
+
+// Drops the last line of a JSON Lines file when it does not parse, as when a crash cut it off,
+// keeping the dropped line aside. Lines are only ever appended, so only the last one can be torn
+// and the rest of the file is not read. Returns the number of dropped lines.
+func repairJSONLines(file string) (int, error) {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return 0, nil
+	}
+
+	// Reads back from the end to the newline before the last line
+	start := int64(0)
+	var tail []byte
+	block := make([]byte, 64<<10)
+	for end := size; end > 0; {
+		offset := end - int64(len(block))
+		if offset < 0 {
+			offset = 0
+		}
+		n := end - offset
+		if _, err := f.ReadAt(block[:n], offset); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(block[:n], '\n'); i >= 0 {
+			start = offset + int64(i) + 1
+			tail = append(bytes.Clone(block[i+1:n]), tail...)
+			break
+		}
+		tail = append(bytes.Clone(block[:n]), tail...)
+		end = offset
+	}
+
+	// Completes the last line when only its newline is missing
+	if len(bytes.TrimSpace(tail)) == 0 || json.Valid(tail) {
+		_, err := f.WriteAt([]byte("\n"), size)
+		return 0, err
+	}
+
+	if err := os.WriteFile(file+".corrupt", tail, 0644); err != nil {
+		return 0, err
+	}
+	log.Printf("WARNING: dropped the damaged last line of %s, it was kept as %s.corrupt", file, file)
+	return 1, f.Truncate(start)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRepairJSONLines(t *testing.T) {
+	long := `{"path":"` + strings.Repeat("x", 100<<10) + `"}`
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		dropped int
+		// Line kept aside, none when empty
+		corrupt string
+	}{
+		{"intact", "{\"a\":1}\n{\"b\":2}\n", "{\"a\":1}\n{\"b\":2}\n", 0, ""},
+		{"empty", "", "", 0, ""},
+		{"missing newline", "{\"a\":1}\n{\"b\":2}", "{\"a\":1}\n{\"b\":2}\n", 0, ""},
+		{"truncated last line", "{\"a\":1}\n{\"b\":2}\n{\"c\":", "{\"a\":1}\n{\"b\":2}\n", 1, "{\"c\":"},
+		{"truncated only line", "{\"path\":\"Bob_1", "", 1, "{\"path\":\"Bob_1"},
+		{"truncated long line", "{\"a\":1}\n" + long[:len(long)-5], "{\"a\":1}\n", 1, long[:len(long)-5]},
+		{"long last line", "{\"a\":1}\n" + long, "{\"a\":1}\n" + long + "\n", 0, ""},
+		// Only the last line can be torn by a crash, the earlier lines are never read
+		{"damaged middle line", "{\"a\":1}\n\x00\x00\x00\n{\"c\":3}\n", "{\"a\":1}\n\x00\x00\x00\n{\"c\":3}\n", 0, ""},
+		{"blank lines", "{\"a\":1}\n\n\n{\"b\":2}\n", "{\"a\":1}\n\n\n{\"b\":2}\n", 0, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), manifestName)
+			if err := os.WriteFile(file, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			dropped, err := repairJSONLines(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped != test.dropped {
+				t.Errorf("dropped %d lines, want %d", dropped, test.dropped)
+			}
+			if got, _ := os.ReadFile(file); string(got) != test.want {
+				t.Errorf("repaired file = %.80q, want %.80q", got, test.want)
+			}
+
+			// The dropped line is kept aside
+			corrupt, err := os.ReadFile(file + ".corrupt")
+			if test.corrupt != "" && (err != nil || string(corrupt) != test.corrupt) {
+				t.Errorf("line kept aside = %.80q, %v, want %.80q", corrupt, err, test.corrupt)
+			}
+			if test.corrupt == "" && !os.IsNotExist(err) {
+				t.Errorf("an intact file was kept aside as corrupt")
+			}
+		})
+	}
+}
+
+func TestRepairJSONLinesMissing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), manifestName)
+	if dropped, err := repairJSONLines(file); dropped != 0 || err != nil {
+		t.Errorf("repairJSONLines of a missing file = %d, %v", dropped, err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("repairJSONLines created the missing file")
+	}
+}
+
+func TestLoadUsageTruncated(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, usageFileName)
+	truncated := `{"month": "2024-01", "monthly": {"page_bytes": 10, "file_b`
+	if err := os.WriteFile(file, []byte(truncated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The counters start over and the damaged file is kept aside
+	u, err := loadUsage(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Total.total() != 0 || u.Creators == nil {
+		t.Errorf("loadUsage of a truncated file = %+v, want empty counters", u)
+	}
+	if data, err := os.ReadFile(file + ".corrupt"); err != nil || string(data) != truncated {
+		t.Errorf("truncated file kept aside = %q, %v", data, err)
+	}
+}
+
+func TestKeepMetadataHistory(t *testing.T) {
+	history := metadataHistory
+	metadataHistory = 2
+	t.Cleanup(func() {
+		metadataHistory = history
+	})
+
+	// Versions replaced within the same second are all kept, up to the limit
+	dir := t.TempDir()
+	file := filepath.Join(dir, "profile.json")
+	old := file + ".20240102-030405"
+	if err := os.WriteFile(old, []byte("version 0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if _, err := writeMetadata(file, []byte(fmt.Sprintf("version %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, entry := range entries {
+		if entry.Name() == "profile.json" {
+			continue
+		}
+		if !metadataVersionPattern.MatchString(strings.TrimPrefix(entry.Name(), "profile.json.")) {
+			t.Errorf("unexpected file %s", entry.Name())
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, string(content))
+	}
+	if want := []string{"version 2", "version 3"}; strings.Join(kept, ",") != strings.Join(want, ",") {
+		t.Errorf("kept versions %q, want %q", kept, want)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("the oldest version in the previous format was not dropped")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "profile.json")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(file); err != nil || string(got) != content {
+			t.Errorf("content = %q, %v, want %q", got, err, content)
+		}
+	}
+	if info, err := os.Stat(file); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Errorf("stat = %v, %v, want mode 0644", info, err)
+	}
+
+	// Failed writes leave no temporary file behind
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "profile.json"), []byte("lost"), 0644); err == nil {
+		t.Error("write into a missing directory succeeded")
+	}
+	if err := os.Mkdir(filepath.Join(dir, "taken"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "taken", "entry"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "taken"), []byte("over a directory"), 0644); err == nil {
+		t.Error("write over a directory succeeded")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "profile.json" && entry.Name() != "taken" {
+			t.Errorf("left %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Videos smaller than this on coomer are likely preview stubs archived instead of the full file
+const stubMaxSize = 1 << 20
+
+// Reports whether a file of the size is likely a preview stub, only coomer archives stubs
+func likelyStub(site string, name string, size int64) bool {
+	return site == "coomer" && size >= 0 && size < stubMaxSize && videoExtensions[strings.ToLower(filepath.Ext(plainName(name)))]
+}
+
+// Reports whether a downloaded stub was replaced by a larger file on the site since
+func stubReplaced(ctx context.Context, c *creator, postURL string, url string, dest string) bool {
+	info, err := os.Stat(dest)
+	if err != nil || !likelyStub(c.site, dest, info.Size()) {
+		return false
+	}
+	return fileSize(withFileTransfer(ctx, postURL), url) > info.Size()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File in the creator's directory keeping the size and modification time of every file
+// when it was hashed into SHA256SUMS, so files changed since are hashed again
+const sumsStatName = ".kemono-dl-sums.json"
+
+// Size and modification time of a file when it was hashed
+type sumStat struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Reads the sizes and modification times of the hashed files, a missing or damaged file gives an
+// empty map so every file is hashed again
+func readSumStats(file string) map[string]sumStat {
+	stats := map[string]sumStat{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return stats
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		log.Printf("Hashing all files again, %s is damaged: %s", filepath.Base(file), err)
+		return map[string]sumStat{}
+	}
+	return stats
+}
+
+// Reads a checksum file in the sha256sum format into a map of relative paths to hashes,
+// a missing file gives an empty map
+func readSums(file string) (map[string]string, error) {
+	sums := map[string]string{}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are "{hash}  {path}", binary mode marks the path with an asterisk instead
+		sum, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || len(name) < 2 {
+			continue
+		}
+		sums[name[1:]] = sum
+	}
+
+	return sums, scanner.Err()
+}
+
+// Writes the checksums in the sha256sum format understood by rclone check --checkfile
+func writeSums(file string, sums map[string]string) error {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s  %s\n", sums[name], name)
+	}
+
+	_, err := writeMetadata(file, []byte(content.String()))
+	return err
+}
+
+// Updates SHA256SUMS in the creator's directory, hashing the downloaded files not listed yet or
+// changed in size or modification time since they were hashed, and dropping the entries of files
+// that were removed. Encrypted files are listed by the hash of their bytes on disk, so the file
+// can be checked with sha256sum or rclone without the key.
+func updateSums(dir string, prefix string) (int, int, error) {
+	file := filepath.Join(dir, tableOfContentsName)
+	sums, err := readSums(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	statFile := filepath.Join(dir, sumsStatName)
+	stats := readSumStats(statFile)
+
+	local, err := localPosts(dir, prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	present := map[string]bool{}
+	hashed := 0
+	for _, files := range local {
+		for _, name := range files {
+			// Unfinished downloads are hashed once they are complete
+			if strings.HasSuffix(name, ".aria2") || externalIncomplete(filepath.Join(dir, name)) {
+				continue
+			}
+
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				return hashed, 0, err
+			}
+			current := sumStat{Size: info.Size(), ModTime: info.ModTime().UTC()}
+
+			present[name] = true
+			if _, ok := sums[name]; ok && stats[name] == current {
+				continue
+			}
+
+			sum, err := hashStoredFile(filepath.Join(dir, name))
+			if err != nil {
+				return hashed, 0, err
+			}
+			sums[name] = sum
+			stats[name] = current
+			hashed++
+		}
+	}
+
+	removed := 0
+	for name := range sums {
+		if !present[name] {
+			delete(sums, name)
+			removed++
+		}
+	}
+	for name := range stats {
+		if !present[name] {
+			delete(stats, name)
+		}
+	}
+
+	if err := writeSums(file, sums); err != nil {
+		return hashed, removed, err
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return hashed, removed, err
+	}
+	if previous, err := os.ReadFile(statFile); err == nil && bytes.Equal(previous, data) {
+		return hashed, removed, nil
+	}
+	return hashed, removed, writeFileAtomic(statFile, data, 0644)
+}
+
+// Verifies the files listed in SHA256SUMS in the directory, returns the number of files that do not match
+func verifySums(dir string) (int, error) {
+	sums, err := readSums(filepath.Join(dir, tableOfContentsName))
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		sum, err := hashStoredFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			log.Printf("%s: %s", name, err)
+			failed++
+		} else if sum != sums[name] {
+			log.Printf("%s: checksum does not match", name)
+			failed++
+		}
+	}
+
+	return failed, nil
+}
+
+// Updates or verifies the checksum file of a creator directory
+func sumsCommand(args []string) error {
+	flags := flag.NewFlagSet("sums", flag.ContinueOnError)
+	check := flags.Bool("check", false, "Verify the files against SHA256SUMS instead of updating it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: kemono-dl sums [--check] DIR")
+	}
+	dir, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *check {
+		failed, err := verifySums(dir)
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d files failed verification", failed)
+		}
+		log.Printf("All files in %s match %s", dir, tableOfContentsName)
+		return nil
+	}
+
+	added, removed, err := updateSums(dir, directoryPrefix(dir))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Updated %s: %d files hashed, %d removed", filepath.Join(dir, tableOfContentsName), added, removed)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateSums(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string, modTime time.Time) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	hour := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	write("Bob_1000_image.jpg", "first image", hour)
+	write("Bob_1000_video.mp4", "first video", hour)
+	write("Bob_999_archive.zip.enc", "sealed bytes", hour)
+	if hashed, removed, err := updateSums(dir, "Bob"); err != nil || hashed != 3 || removed != 0 {
+		t.Fatalf("updateSums = %d, %d, %v, want 3, 0, nil", hashed, removed, err)
+	}
+
+	// Unchanged files are not hashed again
+	if hashed, _, err := updateSums(dir, "Bob"); err != nil || hashed != 0 {
+		t.Fatalf("updateSums of unchanged files = %d, %v, want 0", hashed, err)
+	}
+
+	// Files changed in size or modification time are
+	write("Bob_1000_image.jpg", "other image", hour.Add(time.Minute))
+	write("Bob_1000_video.mp4", "longer first video", hour)
+	os.Remove(filepath.Join(dir, "Bob_999_archive.zip.enc"))
+	if hashed, removed, err := updateSums(dir, "Bob"); err != nil || hashed != 2 || removed != 1 {
+		t.Fatalf("updateSums of changed files = %d, %d, %v, want 2, 1, nil", hashed, removed, err)
+	}
+
+	sums, err := readSums(filepath.Join(dir, tableOfContentsName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Bob_1000_image.jpg": hash("other image"),
+		"Bob_1000_video.mp4": hash("longer first video"),
+	}
+	if len(sums) != len(want) {
+		t.Errorf("SHA256SUMS lists %v, want %v", sums, want)
+	}
+	for name, sum := range want {
+		if sums[name] != sum {
+			t.Errorf("SHA256SUMS lists %s as %s, want %s", name, sums[name], sum)
+		}
+	}
+	if failed, err := verifySums(dir); err != nil || failed != 0 {
+		t.Errorf("verifySums = %d, %v, want 0 failures", failed, err)
+	}
+}
+
+func TestUpdateSumsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Bob_1000_image.jpg.enc"), []byte("sealed bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted files are listed by the bytes on disk, which need no key to check
+	if _, _, err := updateSums(dir, "Bob"); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := readSums(filepath.Join(dir, tableOfContentsName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("sealed bytes"))
+	if got := sums["Bob_1000_image.jpg.enc"]; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("SHA256SUMS lists the encrypted file as %s, want the hash of its bytes", got)
+	}
+	if failed, err := verifySums(dir); err != nil || failed != 0 {
+		t.Errorf("verifySums without the key = %d, %v, want 0 failures", failed, err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Size of the buffer files are copied through, large writes fragment less on NTFS and copy-on-write filesystems
+const downloadBufferSize = 1 << 20
+
+// Buffers the downloads are copied through, shared so concurrent downloads do not allocate a buffer each
+var downloadBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, downloadBufferSize)
+		return &buffer
+	},
+}
+
+// Files of at least this size are preallocated before they are written, smaller files are written
+// at once anyway and are not worth recording the progress of
+var preallocMinSize int64 = 16 << 20
+
+// Copies the body into the file through a pooled buffer, returns the number of bytes copied
+func copyDownload(dst io.Writer, src io.Reader) (int64, error) {
+	buffer := downloadBuffers.Get().(*[]byte)
+	defer downloadBuffers.Put(buffer)
+
+	// Hides the ReadFrom of the file, which would copy through a small buffer of its own
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buffer)
+}
+
+// Downloads the file over a single connection into the unfinished downloads of the creator, resumed
+// even when the file is named differently, and moves it into place once complete. Large files of
+// a known size are preallocated, their progress is recorded like a chunked download of a single
+// range. Returns the number of bytes transferred.
+func singleDownload(ctx context.Context, url string, file string) (int64, error) {
+	partial := partialPath(url, file)
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := continueDownload(ctx, url, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+
+	os.Remove(partial + chunkStateSuffix)
+	return n, moveFile(partial, file)
+}
+
+// Continues the unfinished download where it stopped, returns the number of bytes transferred
+func continueDownload(ctx context.Context, url string, out *os.File) (int64, error) {
+	offset, size := downloadProgress(out)
+	res, offset, size, err := openDownload(ctx, url, offset, size)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Preallocates the rest of a large file. Its progress is only recorded when the download breaks
+	// off, flushing it before would flush the other downloads on the filesystem as well. A preallocated
+	// file without a record is asked for after its end, which the server refuses, and starts over.
+	var state *chunkState
+	if offset == 0 {
+		err = out.Truncate(0)
+	}
+	if err == nil && size >= preallocMinSize {
+		state = &chunkState{Size: size, Received: []int64{offset}}
+		err = out.Truncate(size)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := copyDownload(io.NewOffsetWriter(out, offset), res.Body)
+	if err == nil && size >= 0 && offset+n != size {
+		err = fmt.Errorf("%s: downloaded %d of %d bytes: %w", url, offset+n, size, io.ErrUnexpectedEOF)
+	}
+
+	// Keeps the received bytes for the next attempt, a complete download needs no record
+	if err != nil && state != nil {
+		state.Received[0] += n
+		if err := state.save(out); err != nil {
+			log.Printf("Failed to record the progress of %s: %s", filepath.Base(out.Name()), err)
+		}
+	}
+	return n, err
+}
+
+// Returns the bytes received by the unfinished download and the size of the file when known, -1 otherwise.
+// Downloads without a record of their progress were not preallocated, all their bytes were received.
+func downloadProgress(out *os.File) (int64, int64) {
+	info, err := out.Stat()
+	if err != nil {
+		return 0, -1
+	}
+
+	data, err := os.ReadFile(out.Name() + chunkStateSuffix)
+	if os.IsNotExist(err) {
+		return info.Size(), -1
+	}
+	// Progress of a chunked download of several ranges starts over
+	var state chunkState
+	if err != nil || json.Unmarshal(data, &state) != nil || len(state.Received) != 1 || state.Size != info.Size() {
+		return 0, -1
+	}
+	return state.Received[0], state.Size
+}
+
+// Requests the file from the offset, or from the start when the server does not continue
+// the download there. Returns the response with the offset and size of the file it carries.
+func openDownload(ctx context.Context, url string, offset int64, size int64) (*http.Response, int64, int64, error) {
+	// Files are transferred as stored so their length matches Content-Length and
+	// unfinished downloads can be resumed, pages keep the transport's gzip negotiation
+	header := http.Header{"Accept-Encoding": {"identity"}}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := doRequest(ctx, http.MethodGet, url, header)
+
+	// A download received whole but never moved into place asks for nothing
+	var resErr *ResponseError
+	if offset > 0 && errors.As(err, &resErr) && resErr.Status == http.StatusRequestedRangeNotSatisfiable {
+		return openDownload(ctx, url, 0, -1)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		return res, 0, res.ContentLength, nil
+	}
+
+	// Content-Range: bytes {start}-{end}/{size}
+	start, total, ok := contentRange(res.Header.Get("Content-Range"))
+	if !ok || start != offset || size >= 0 && total != size {
+		res.Body.Close()
+		return openDownload(ctx, url, 0, -1)
+	}
+	return res, offset, total, nil
+}
+
+// Returns the first byte and the size of the file of a Content-Range header
+func contentRange(value string) (int64, int64, bool) {
+	spec, total, ok := strings.Cut(strings.TrimPrefix(value, "bytes "), "/")
+	first, _, found := strings.Cut(spec, "-")
+	if !ok || !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentRange(t *testing.T) {
+	tests := []struct {
+		value string
+		start int64
+		size  int64
+		ok    bool
+	}{
+		{"bytes 0-99/100", 0, 100, true},
+		{"bytes 50-99/100", 50, 100, true},
+		{"bytes */100", 0, 0, false},
+		{"bytes 0-99/*", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, size, ok := contentRange(test.value)
+		if start != test.start || size != test.size || ok != test.ok {
+			t.Errorf("contentRange(%q) = %d, %d, %t, want %d, %d, %t", test.value, start, size, ok, test.start, test.size, test.ok)
+		}
+	}
+}
+
+func TestDownloadFilePreallocates(t *testing.T) {
+	testRun(t)
+	minSize := preallocMinSize
+	preallocMinSize = 1
+	t.Cleanup(func() {
+		preallocMinSize = minSize
+	})
+	site := newMockSite(t, 1)
+	link := mockFile("preallocated", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+	site.fail(path, mockResponse{status: http.StatusOK, body: string(content), truncate: true})
+	dest := filepath.Join(t.TempDir(), "video.mp4")
+	partial := partialPath(site.URL+link, dest)
+
+	// The broken off download keeps its full size with the received bytes recorded next to it
+	if _, err := downloadFile(context.Background(), site.URL+link, dest, &config{}); err == nil {
+		t.Fatal("a truncated download succeeded")
+	}
+	if info, err := os.Stat(partial); err != nil || info.Size() != int64(len(content)) {
+		t.Fatalf("unfinished download = %v, %v, want %d bytes", info, err, len(content))
+	}
+	data, err := os.ReadFile(partial + chunkStateSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil || len(state.Received) != 1 || state.Received[0] != int64(len(content)/2) {
+		t.Fatalf("progress = %s, %v, want %d bytes received", data, err, len(content)/2)
+	}
+
+	// The next attempt asks for the rest only
+	downloaded, err := downloadFile(context.Background(), site.URL+link, dest, &config{})
+	if err != nil || !downloaded {
+		t.Fatalf("resumed downloadFile = %t, %v", downloaded, err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("resumed content differs")
+	}
+	site.mu.Lock()
+	rangeHeader := site.headers[len(site.headers)-1].Get("Range")
+	site.mu.Unlock()
+	if want := fmt.Sprintf("bytes=%d-", len(content)/2); rangeHeader != want {
+		t.Errorf("resumed with Range %q, want %q", rangeHeader, want)
+	}
+	for _, file := range []string{partial, partial + chunkStateSuffix} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("left %s", filepath.Base(file))
+		}
+	}
+}
+
+func TestDownloadFileReceivedWhole(t *testing.T) {
+	testRun(t)
+	site := newMockSite(t, 1)
+	link := mockFile("received whole", "video.mp4")
+	path, _, _ := strings.Cut(link, "?")
+	content := mockContents[path]
+
+	// A download received whole but never moved into place starts over when there is nothing left to ask for
+	dest := filepath.Join(t.TempDir(), "video.mp4")
+	partial := partialPath(site.URL+link, dest)
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := downloadFile(context.Background(), site.URL+link, dest, &config{})
+	if err != nil || !downloaded {
+		t.Fatalf("downloadFile = %t, %v", downloaded, err)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+		t.Fatal("downloaded content differs")
+	}
+	if n := site.count(path); n != 2 {
+		t.Errorf("requested the file %d times, want the refused range and the whole file", n)
+	}
+}
+
+// Downloads a large file from a local server, the reported throughput compares the copy buffers
+func BenchmarkDownloadFile(b *testing.B) {
+	content := bytes.Repeat([]byte("kemono-dl benchmark\n"), 256<<20/20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	interval := limiter.interval
+	limiter.interval = 0
+	defer func() {
+		limiter.interval = interval
+	}()
+
+	dir := b.TempDir()
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dest := filepath.Join(dir, fmt.Sprintf("%d.bin", i))
+		if _, err := downloadFile(context.Background(), srv.URL+"/large.bin", dest, &config{}); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		os.Remove(dest)
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Name of the file in the base directory accumulating the transferred data across the runs
+// with --monthly-budget
+const usageFileName = ".kemono-dl-usage.json"
+
+// Bytes received for the pages of the site and for the downloaded files
+type usageCounters struct {
+	Pages int64 `json:"page_bytes"`
+	Files int64 `json:"file_bytes"`
+}
+
+// Returns the sum of the page and file bytes
+func (u usageCounters) total() int64 {
+	return u.Pages + u.Files
+}
+
+// Data transferred across runs, the monthly counters restart with every calendar month
+type usage struct {
+	Month    string                    `json:"month"`
+	Monthly  usageCounters             `json:"monthly"`
+	Total    usageCounters             `json:"total"`
+	Creators map[string]*usageCounters `json:"creators"`
+}
+
+// Data transferred by the current run
+var transferred struct {
+	pages atomic.Int64
+	files atomic.Int64
+}
+
+// Marks the context of file downloads with the URL of their post, so their
+// bytes are not accounted as pages and they are sent with the file headers
+type fileTransferKey struct{}
+
+// Returns a context marking the requests sent with it as downloads of the post's files
+func withFileTransfer(ctx context.Context, postURL string) context.Context {
+	return context.WithValue(ctx, fileTransferKey{}, postURL)
+}
+
+// Returns the post URL of a file download, reports whether the request downloads a file
+func fileTransfer(req *http.Request) (string, bool) {
+	postURL, ok := req.Context().Value(fileTransferKey{}).(string)
+	return postURL, ok
+}
+
+// Counts the bytes of the page bodies read through the client
+func accountTransfers(next sendFunc) sendFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		res, err := next(req)
+		if _, file := fileTransfer(req); err == nil && !file {
+			res.Body = &countingBody{ReadCloser: res.Body, count: &transferred.pages}
+		}
+		return res, err
+	}
+}
+
+// Response body adding the bytes read to a counter
+type countingBody struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count.Add(int64(n))
+	return n, err
+}
+
+// Records a downloaded file in the metrics, the run budget and the usage
+func fileTransferred(bytes int64) {
+	runMetrics.fileDownloaded(bytes)
+	runBudget.add(bytes)
+	transferred.files.Add(bytes)
+}
+
+// Returns the pages and files transferred by the run so far
+func transferredBytes() int64 {
+	return transferred.pages.Load() + transferred.files.Load()
+}
+
+// Sets the run budget to what is left of this month's budget recorded in the base directory,
+// reports whether anything is left
+func startMonthlyBudget(base string, monthly int64) (bool, error) {
+	u, err := loadUsage(base)
+	if err != nil {
+		return false, err
+	}
+
+	remaining := monthly - u.Monthly.total()
+	if remaining <= 0 {
+		log.Printf("The monthly budget of %s is used up for %s", formatSize(monthly), u.Month)
+		return false, nil
+	}
+	runBudget.setMonthly(remaining)
+	return true, nil
+}
+
+// Returns the current month in the format stored in the usage file
+func currentMonth() string {
+	return time.Now().Format("2006-01")
+}
+
+// Reads the usage file in the base directory, starting the monthly counters over in a new month
+func loadUsage(base string) (*usage, error) {
+	u := &usage{Creators: map[string]*usageCounters{}}
+
+	data, err := os.ReadFile(filepath.Join(base, usageFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		// The counters start over when the file is damaged
+		if err := json.Unmarshal(data, u); err != nil {
+			setAsideCorrupt(filepath.Join(base, usageFileName), err)
+			u = &usage{}
+		}
+		if u.Creators == nil {
+			u.Creators = map[string]*usageCounters{}
+		}
+	}
+
+	if month := currentMonth(); u.Month != month {
+		u.Month = month
+		u.Monthly = usageCounters{}
+	}
+
+	return u, nil
+}
+
+// Adds the data transferred for a creator to the usage file in the base directory
+func recordUsage(base string, creator string, transfer usageCounters) error {
+	u, err := loadUsage(base)
+	if err != nil {
+		return err
+	}
+
+	for _, counters := range []*usageCounters{&u.Monthly, &u.Total} {
+		counters.Pages += transfer.Pages
+		counters.Files += transfer.Files
+	}
+	if u.Creators[creator] == nil {
+		u.Creators[creator] = &usageCounters{}
+	}
+	u.Creators[creator].Pages += transfer.Pages
+	u.Creators[creator].Files += transfer.Files
+
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(base, usageFileName), data, 0644)
+}
+
+// Prints the data transferred this month, in total and per creator, recorded in the base directory
+func statsCommand(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: kemono-dl stats [DIR]")
+	}
+
+	var base string
+	if len(args) == 1 {
+		base = args[0]
+	} else {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		base = wd
+	}
+
+	u, err := loadUsage(base)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("This month (%s): %s (pages %s, files %s)\n", u.Month, formatSize(u.Monthly.total()), formatSize(u.Monthly.Pages), formatSize(u.Monthly.Files))
+	fmt.Printf("All time: %s (pages %s, files %s)\n", formatSize(u.Total.total()), formatSize(u.Total.Pages), formatSize(u.Total.Files))
+
+	creators := make([]string, 0, len(u.Creators))
+	for creator := range u.Creators {
+		creators = append(creators, creator)
+	}
+	sort.Strings(creators)
+	for _, creator := range creators {
+		c := u.Creators[creator]
+		fmt.Printf("  %s: %s (pages %s, files %s)\n", creator, formatSize(c.total()), formatSize(c.Pages), formatSize(c.Files))
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Size variants Fantia serves its images in, named by the prefix of the file name.
+// Files without a prefix are the originals.
+var fantiaVariantPattern = regexp.MustCompile(`^(thumb_micro|thumb|main_webp|main|large|original)_(.+)$`)
+
+// Size variants preferred by each --prefer-size value, most preferred first
+var variantPreferences = map[string][]string{
+	"original": {"original", "large", "main", "main_webp", "thumb", "thumb_micro"},
+	"large":    {"large", "main", "main_webp", "original", "thumb", "thumb_micro"},
+}
+
+// Checks the --prefer-size value
+func checkPreferSize(size string) error {
+	if _, ok := variantPreferences[size]; ok || size == "any" {
+		return nil
+	}
+	return fmt.Errorf("unknown size %q, expected original, large or any", size)
+}
+
+// Returns the size variant of an image of a Fantia post and the name of the image without it,
+// or an empty variant for the files of other services
+func fantiaVariant(postURL string, rawURL string) (string, string) {
+	name := originalFileName(rawURL)
+	if !strings.Contains(postURL, "/fantia/") {
+		return "", name
+	}
+	if match := fantiaVariantPattern.FindStringSubmatch(name); match != nil {
+		return match[1], match[2]
+	}
+	return "original", name
+}
+
+// Returns the positions of the files of a Fantia post which are smaller or larger variants
+// of another file of the post, keeping the variant preferred by --prefer-size
+func (c *creator) skippedVariants(post post) map[int]bool {
+	preference, ok := variantPreferences[c.cfg.preferSize]
+	if !ok || c.service != "fantia" {
+		return nil
+	}
+
+	// Groups the files showing the same image
+	groups := map[string][]int{}
+	variants := make([]string, len(post.files))
+	for i, file := range post.files {
+		variant, name := fantiaVariant(post.url, file)
+		variants[i] = variant
+		groups[name] = append(groups[name], i)
+	}
+
+	skipped := map[int]bool{}
+	for _, files := range groups {
+		if len(files) < 2 {
+			continue
+		}
+
+		// Keeps the first file of the most preferred variant present
+		keep := -1
+		for _, wanted := range preference {
+			for _, i := range files {
+				if variants[i] == wanted {
+					keep = i
+					break
+				}
+			}
+			if keep >= 0 {
+				break
+			}
+		}
+		if keep < 0 {
+			continue
+		}
+		for _, i := range files {
+			if i != keep {
+				skipped[i] = true
+			}
+		}
+	}
+
+	return skipped
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Name of the file in the base directory whose modification triggers an immediate re-check in watch mode
+const recheckFileName = ".kemono-dl-recheck"
+
+// How often the re-check file is looked at while waiting for the next cycle
+const recheckPollInterval = 5 * time.Second
+
+// Runs the cycle repeatedly until the context is cancelled. The interval between
+// cycles is randomly extended by up to a tenth so that multiple instances don't
+// synchronize. SIGHUP or touching the re-check file starts the next cycle immediately.
+func watch(ctx context.Context, interval time.Duration, recheckFile string, cycle func(ctx context.Context)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	lastTouch := modTime(recheckFile)
+	for {
+		cycle(ctx)
+		if ctx.Err() != nil {
+			log.Println("Watch mode stopped")
+			return
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(interval)/10 + 1))
+		next := time.Now().Add(interval + jitter)
+		log.Printf("Next check scheduled at %s", next.Format(time.RFC1123))
+
+		timer := time.NewTimer(time.Until(next))
+		poll := time.NewTicker(recheckPollInterval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				poll.Stop()
+				log.Println("Watch mode stopped")
+				return
+			case <-hup:
+				log.Println("Received SIGHUP, checking now")
+				break wait
+			case <-poll.C:
+				if touch := modTime(recheckFile); touch.After(lastTouch) {
+					lastTouch = touch
+					log.Printf("%s was touched, checking now", recheckFile)
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+		timer.Stop()
+		poll.Stop()
+	}
+}
+
+// Returns the modification time of a file, zero when it doesn't exist
+func modTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
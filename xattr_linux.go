@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// Sets an extended attribute of the file
+func setXattr(file string, name string, value string) error {
+	return syscall.Setxattr(file, name, []byte(value), 0)
+}
+
+// Returns an extended attribute of the file
+func getXattr(file string, name string) (string, error) {
+	size, err := syscall.Getxattr(file, name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(file, name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Extended attributes are only written on Linux
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// Sets an extended attribute of the file
+func setXattr(file string, name string, value string) error {
+	return errXattrUnsupported
+}
+
+// Returns an extended attribute of the file
+func getXattr(file string, name string) (string, error) {
+	return "", errXattrUnsupported
+}